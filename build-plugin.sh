@@ -167,7 +167,8 @@ function build_migration() {
     pushd "$mig"
     go mod edit -replace "${IPFS_REPO}=${BUILD_GOIPFS}"
     go mod vendor
-    go build -mod=vendor
+    # The plugins tag makes the migration load go-ipfs plugins.
+    go build -mod=vendor -tags plugins
     # Cleanup temporary modifications
     rm -rf vendor
     git checkout vendor go.mod
@@ -8,6 +8,7 @@ import (
 	lock "github.com/ipfs/fs-repo-migrations/fs-repo-0-to-1/repolock"
 	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
 	mfsr "github.com/ipfs/fs-repo-migrations/tools/mfsr"
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
 )
 
 type Migration struct {
@@ -26,9 +27,24 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Adds the version file to a repo created before repos were versioned.",
+		Areas:   []string{},
+	}
+}
+
+// Estimate returns the cost of the migration, which only writes a file.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	return migrate.Cost{Note: "only the version file is written"}, nil
+}
+
 // Apply applies the migration in question.
 // This migration merely adds a version file.
 func (m Migration) Apply(opts migrate.Options) error {
+	log.Verbose = opts.Verbose
+
 	repolk, err := lock.Lock(opts.Path)
 	if err != nil {
 		return err
@@ -50,17 +66,17 @@ func (m Migration) Apply(opts migrate.Options) error {
 		return err
 	}
 
-	if opts.Verbose {
-		fmt.Println("wrote version file")
-	}
+	log.VLog("wrote version file")
 
-	fmt.Println("Migration 0 to 1 succeeded")
+	log.Log("Migration 0 to 1 succeeded")
 	return nil
 }
 
 // Revert un-applies the migration in question. This should be best-effort.
 // Some migrations are definitively one-way. If so, return an error.
 func (m Migration) Revert(opts migrate.Options) error {
+	log.Verbose = opts.Verbose
+
 	lk, err := lock.Lock(opts.Path)
 	if err != nil {
 		return err
@@ -83,9 +99,7 @@ func (m Migration) Revert(opts migrate.Options) error {
 	if err := os.Remove(repo.VersionFile()); err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("deleted version file")
-	}
+	log.VLog("deleted version file")
 
 	return nil
 }
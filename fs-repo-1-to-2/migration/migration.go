@@ -14,6 +14,7 @@ import (
 	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
 	mfsr "github.com/ipfs/fs-repo-migrations/tools/mfsr"
 	lock "github.com/ipfs/fs-repo-migrations/tools/repolock"
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
 )
 
 const peerKeyName = "peer.key"
@@ -28,7 +29,52 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Moves the blocks out of the leveldb datastore into a flatfs datastore in blocks/, and moves the repo from ~/.go-ipfs to ~/.ipfs.",
+		// The config is not edited, but moves with the repo.
+		Areas: []string{migrate.AreaConfig, migrate.AreaBlocks},
+	}
+}
+
+// Estimate returns the size of the leveldb datastore the blocks are copied
+// from.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	_, bytes, err := migrate.DirUsage(path.Join(repoPath, "datastore"), "")
+	return migrate.Cost{Bytes: bytes, Note: "size of the leveldb datastore the blocks are copied from"}, err
+}
+
+// Preflight counts the blocks in leveldb. Each is written to a file of its own
+// in a directory of its own, and leveldb only frees the space of the blocks
+// deleted from it when it compacts, so all of it is needed again.
+func (m Migration) Preflight(repoPath string) (migrate.Space, error) {
+	ldbpath := path.Join(repoPath, "datastore")
+	_, bytes, err := migrate.DirUsage(ldbpath, "")
+	if err != nil {
+		return migrate.Space{}, err
+	}
+	ldb, err := leveldb.NewDatastore(ldbpath, &leveldb.Options{ErrorIfMissing: true})
+	if err != nil {
+		return migrate.Space{}, err
+	}
+	defer ldb.Close()
+
+	n, err := countKeys(ldb, "/b/")
+	if err != nil {
+		return migrate.Space{}, err
+	}
+	return migrate.Space{
+		Path:  repoPath,
+		Bytes: bytes,
+		Files: n,
+		Dirs:  n,
+		Note:  "the size of the leveldb datastore is taken as the size of the blocks",
+	}, nil
+}
+
 func (m Migration) Apply(opts migrate.Options) error {
+	log.Verbose = opts.Verbose
 
 	// lock the daemon.lock file. and if we succeed, remove it at the end.
 	// we remove it because camlistore/lock doesn't, and we changed the filename.
@@ -55,27 +101,21 @@ func (m Migration) Apply(opts migrate.Options) error {
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("performed sanity check")
-	}
+	log.VLog("performed sanity check")
 
 	// 2) Transfer blocks out of leveldb into flatDB
-	err = transferBlocksToFlatDB(opts.Path, opts.Verbose)
+	err = transferBlocksToFlatDB(opts.Path)
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("moved blocks from leveldb to flatfs")
-	}
+	log.VLog("moved blocks from leveldb to flatfs")
 
 	// 3) move ipfs path from .go-ipfs to .ipfs
 	newpath, err := moveIpfsDir(opts.Path)
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("moved ipfs directory from .go-ipfs to .ipfs")
-	}
+	log.VLog("moved ipfs directory from .go-ipfs to .ipfs")
 
 	// 4) Update version number
 	repo = mfsr.RepoPath(newpath)
@@ -83,23 +123,21 @@ func (m Migration) Apply(opts migrate.Options) error {
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("updated version file")
-	}
+	log.VLog("updated version file")
 
 	// 5) Remove daemon.lock file
-	if opts.Verbose {
-		fmt.Println("removing daemon.lock file")
-	}
+	log.VLog("removing daemon.lock file")
 	repolk.Close()
 	closedLock = true
 	lock.Remove1(newpath) // ok if this fails.
 
-	fmt.Println("Migration 1 to 2 succeeded")
+	log.Log("Migration 1 to 2 succeeded")
 	return nil
 }
 
 func (m Migration) Revert(opts migrate.Options) error {
+	log.Verbose = opts.Verbose
+
 	repolk, err := lock.Lock2(opts.Path) // lock repo.lock
 	if err != nil {
 		return err
@@ -116,18 +154,14 @@ func (m Migration) Revert(opts migrate.Options) error {
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("moved ipfs directory from .ipfs to .go-ipfs")
-	}
+	log.VLog("moved ipfs directory from .ipfs to .go-ipfs")
 
 	// 2) move blocks back from flatfs to leveldb
-	err = transferBlocksFromFlatDB(npath, opts.Verbose)
+	err = transferBlocksFromFlatDB(npath)
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("moved blocks from flatfs to leveldb")
-	}
+	log.VLog("moved blocks from flatfs to leveldb")
 
 	// 3) change version number back down
 	repo = mfsr.RepoPath(npath)
@@ -135,9 +169,7 @@ func (m Migration) Revert(opts migrate.Options) error {
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("lowered version number to 1")
-	}
+	log.VLog("lowered version number to 1")
 
 	return nil
 }
@@ -163,7 +195,7 @@ func sanityChecks(opts migrate.Options) error {
 	return nil
 }
 
-func transferBlocksToFlatDB(repopath string, verbose bool) error {
+func transferBlocksToFlatDB(repopath string) error {
 	ldbpath := path.Join(repopath, "datastore")
 	ldb, err := leveldb.NewDatastore(ldbpath, nil)
 	if err != nil {
@@ -181,10 +213,10 @@ func transferBlocksToFlatDB(repopath string, verbose bool) error {
 		return err
 	}
 
-	return transferBlocks(ldb, fds, "/b/", "", verbose)
+	return transferBlocks(ldb, fds, "/b/", "")
 }
 
-func transferBlocksFromFlatDB(repopath string, verbose bool) error {
+func transferBlocksFromFlatDB(repopath string) error {
 
 	ldbpath := path.Join(repopath, "datastore")
 	blockspath := path.Join(repopath, "blocks")
@@ -198,7 +230,7 @@ func transferBlocksFromFlatDB(repopath string, verbose bool) error {
 		return err
 	}
 
-	err = transferBlocks(fds, ldb, "", "/b/", verbose)
+	err = transferBlocks(fds, ldb, "", "/b/")
 	if err != nil {
 		return err
 	}
@@ -212,26 +244,37 @@ func transferBlocksFromFlatDB(repopath string, verbose bool) error {
 	return nil
 }
 
-func transferBlocks(from, to dstore.Datastore, fpref, tpref string, verbose bool) error {
-	q := dsq.Query{Prefix: fpref, KeysOnly: true}
-	res, err := from.Query(q)
+// countKeys returns the number of keys under prefix in ds.
+func countKeys(ds dstore.Datastore, prefix string) (int64, error) {
+	res, err := ds.Query(dsq.Query{Prefix: prefix, KeysOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for result := range res.Next() {
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		n++
+	}
+	return n, nil
+}
+
+func transferBlocks(from, to dstore.Datastore, fpref, tpref string) error {
+	n, err := countKeys(from, fpref)
 	if err != nil {
 		return err
 	}
+	prog := migrate.NewProgress("blocks", uint64(n))
+	defer prog.Done()
 
-	showProgress := func(i int) {}
-	if verbose {
-		showProgress = func(i int) {
-			fmt.Printf("\rmoving objects: %d", i)
-		}
-		defer fmt.Println("")
+	q := dsq.Query{Prefix: fpref, KeysOnly: true}
+	res, err := from.Query(q)
+	if err != nil {
+		return err
 	}
 
-	i := 0
 	for result := range res.Next() {
-		i++
-		showProgress(i)
-
 		nkey := fmt.Sprintf("%s%s", tpref, result.Key[len(fpref):])
 
 		fkey := dstore.NewKey(result.Key)
@@ -249,6 +292,7 @@ func transferBlocks(from, to dstore.Datastore, fpref, tpref string, verbose bool
 		if err != nil {
 			return err
 		}
+		prog.Add(1)
 	}
 
 	return nil
@@ -3,9 +3,13 @@ module github.com/ipfs/fs-repo-migrations/fs-repo-10-to-11
 go 1.15
 
 require (
+	github.com/dustin/go-humanize v1.0.0
 	github.com/ipfs/fs-repo-migrations/tools v0.0.0-20210323144402-297a63449538
 	github.com/ipfs/go-blockservice v0.1.4
 	github.com/ipfs/go-datastore v0.4.5
+	github.com/ipfs/go-ds-badger v0.2.6
+	github.com/ipfs/go-ds-flatfs v0.4.5
+	github.com/ipfs/go-ds-leveldb v0.4.2
 	github.com/ipfs/go-filestore v0.0.3
 	github.com/ipfs/go-ipfs v0.7.1-0.20210323141657-684b8b5bb7b0
 	github.com/ipfs/go-ipfs-blockstore v0.1.4
@@ -13,4 +17,7 @@ require (
 	github.com/ipfs/go-ipfs-pinner v0.1.1
 	github.com/ipfs/go-ipld-format v0.2.0
 	github.com/ipfs/go-merkledag v0.3.2
+	github.com/syndtr/goleveldb v1.0.0
 )
+
+replace github.com/ipfs/fs-repo-migrations/tools => ../tools
@@ -4,9 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"io"
+	"io/ioutil"
 	"path"
+	"path/filepath"
+	"strings"
 
 	"github.com/ipfs/go-blockservice"
 	"github.com/ipfs/go-datastore"
@@ -14,25 +16,18 @@ import (
 	blockstore "github.com/ipfs/go-ipfs-blockstore"
 	offline "github.com/ipfs/go-ipfs-exchange-offline"
 	"github.com/ipfs/go-ipfs-pinner/pinconv"
-	"github.com/ipfs/go-ipfs/plugin/loader"
-	"github.com/ipfs/go-ipfs/repo"
 	"github.com/ipfs/go-ipfs/repo/fsrepo"
 	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
 	format "github.com/ipfs/go-ipld-format"
 	"github.com/ipfs/go-merkledag"
 
 	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+	lock "github.com/ipfs/fs-repo-migrations/tools/repolock"
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
 )
 
 type Migration struct{}
 
-var verbose bool
-
-func init() {
-	log.SetOutput(os.Stdout)
-	log.SetFlags(0)
-}
-
 func (m Migration) Versions() string {
 	return "10-to-11"
 }
@@ -41,13 +36,28 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Moves the pins from the pin sets stored as blocks to the datastore, where they are indexed by CID.",
+		Areas:   []string{migrate.AreaPins},
+	}
+}
+
+// Estimate returns the size of the datastore that the pins are written to.
+// The pin sets are read from the blockstore, but only the blocks holding them.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	_, bytes, err := migrate.DirUsage(path.Join(repoPath, "datastore"), "")
+	return migrate.Cost{Bytes: bytes, Note: "size of the datastore the pins are written to"}, err
+}
+
 func (m Migration) Apply(opts migrate.Options) error {
 	const (
 		fromVer = 10
 		toVer   = 11
 	)
-	verbose = opts.Verbose
-	log.Printf("applying %s repo migration", m.Versions())
+	log.Verbose = opts.Verbose
+	log.Log("applying %s repo migration", m.Versions())
 
 	ver, err := migrations.RepoVersion(opts.Path)
 	if err != nil {
@@ -58,31 +68,16 @@ func (m Migration) Apply(opts migrate.Options) error {
 		return fmt.Errorf("versions differ (expected: %d, actual: %d)", fromVer, ver)
 	}
 
-	if err = setupPlugins(opts.Path); err != nil {
-		return fmt.Errorf("failed to setup plugins: %v", err)
-	}
-
-	// Set to previous version to avoid "needs migration" error.  This is safe
-	// for this migration since repo has not changed.
-	fsrepo.RepoVersion = 10
-
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if !fsrepo.IsInitialized(opts.Path) {
-		return fmt.Errorf("ipfs repo %q not initialized", opts.Path)
-	}
-
-	if verbose {
-		log.Printf("opening datastore at %q", opts.Path)
-	}
-	r, err := fsrepo.Open(opts.Path)
+	dstore, closer, err := openDatastore(opts.Path)
 	if err != nil {
-		return fmt.Errorf("cannot open datastore: %v", err)
+		return err
 	}
-	defer r.Close()
+	defer closer.Close()
 
-	if err = transferPins(ctx, r); err != nil {
+	if err = transferPins(ctx, dstore); err != nil {
 		return fmt.Errorf("failed to transfer pins: %v", err)
 	}
 
@@ -91,37 +86,25 @@ func (m Migration) Apply(opts migrate.Options) error {
 		return fmt.Errorf("failed to update version file to %d: %v", toVer, err)
 	}
 
-	log.Print("updated version file")
-	log.Printf("Migration %d to %d succeeded", fromVer, toVer)
+	log.Log("updated version file")
+	log.Log("Migration %d to %d succeeded", fromVer, toVer)
 	return nil
 }
 
 func (m Migration) Revert(opts migrate.Options) error {
-	verbose = opts.Verbose
-	log.Print("reverting migration")
-
-	err := setupPlugins(opts.Path)
-	if err != nil {
-		return fmt.Errorf("failed to setup plugins: %v", err)
-	}
+	log.Verbose = opts.Verbose
+	log.Log("reverting migration")
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	if !fsrepo.IsInitialized(opts.Path) {
-		return fmt.Errorf("ipfs repo %q not initialized", opts.Path)
-	}
-
-	if verbose {
-		log.Printf("opening datastore at %q", opts.Path)
-	}
-	r, err := fsrepo.Open(opts.Path)
+	dstore, closer, err := openDatastore(opts.Path)
 	if err != nil {
-		return fmt.Errorf("cannot open datastore: %v", err)
+		return err
 	}
-	defer r.Close()
+	defer closer.Close()
 
-	if err = revertPins(ctx, r); err != nil {
+	if err = revertPins(ctx, dstore); err != nil {
 		return err
 	}
 
@@ -130,7 +113,7 @@ func (m Migration) Revert(opts migrate.Options) error {
 		return fmt.Errorf("failed to update version file to 10: %v", err)
 	}
 
-	log.Print("updated version file")
+	log.Log("updated version file")
 	return nil
 }
 
@@ -151,27 +134,80 @@ func (d *batchWrap) Batch() (datastore.Batch, error) {
 	return datastore.NewBasicBatch(d), nil
 }
 
-func setupPlugins(externalPluginsPath string) error {
-	// Load any external plugins if available on externalPluginsPath
-	plugins, err := loader.NewPluginLoader(path.Join(externalPluginsPath, "plugins"))
+// setupPlugins loads the go-ipfs plugins with loadPlugins, so that the
+// datastore of the repo at repoPath can be opened. They are injected once per
+// process, as other migrations run in the process may share them.
+func setupPlugins(repoPath string) error {
+	return migrate.Once(migrate.IpfsPlugins, func() error {
+		return loadPlugins(repoPath)
+	})
+}
+
+// openDatastore locks the repo at repoPath and opens its datastore as its
+// config says, after checking that the config matches the datastore on disk.
+// Unlike fsrepo.Open it does not check the repo version, which this
+// migration changes. Closing the returned closer closes the datastore and
+// releases the lock.
+func openDatastore(repoPath string) (datastore.Batching, io.Closer, error) {
+	if !fsrepo.IsInitialized(repoPath) {
+		return nil, nil, fmt.Errorf("ipfs repo %q not initialized", repoPath)
+	}
+
+	if err := setupPlugins(repoPath); err != nil {
+		return nil, nil, fmt.Errorf("failed to setup plugins: %v", err)
+	}
+
+	lk, err := lock.Lock2(repoPath)
 	if err != nil {
-		return fmt.Errorf("error loading plugins: %s", err)
+		return nil, nil, err
 	}
 
-	// Load preloaded and external plugins
-	if err := plugins.Initialize(); err != nil {
-		return fmt.Errorf("error initializing plugins: %s", err)
+	log.VLog("opening datastore at %q", repoPath)
+	dstore, err := createDatastore(repoPath)
+	if err != nil {
+		lk.Close()
+		return nil, nil, fmt.Errorf("cannot open datastore: %v", err)
 	}
+	return dstore, &repoCloser{dstore, lk}, nil
+}
 
-	if err := plugins.Inject(); err != nil {
-		return fmt.Errorf("error initializing plugins: %s", err)
+func createDatastore(repoPath string) (datastore.Batching, error) {
+	cfg, err := fsrepo.ConfigAt(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Datastore.Spec == nil {
+		return nil, fmt.Errorf("required Datastore.Spec entry missing from config file")
+	}
+	dsc, err := fsrepo.AnyDatastoreConfig(cfg.Datastore.Spec)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	b, err := ioutil.ReadFile(filepath.Join(repoPath, "datastore_spec"))
+	if err != nil {
+		return nil, err
+	}
+	oldSpec, spec := strings.TrimSpace(string(b)), dsc.DiskSpec().String()
+	if oldSpec != spec {
+		return nil, fmt.Errorf("datastore configuration of '%s' does not match what is on disk '%s'", oldSpec, spec)
+	}
+	return dsc.Create(repoPath)
+}
+
+// repoCloser closes the datastore, then releases the repo lock.
+type repoCloser struct {
+	dstore datastore.Batching
+	lk     io.Closer
+}
+
+func (c *repoCloser) Close() error {
+	err := c.dstore.Close()
+	c.lk.Close()
+	return err
 }
 
-func makeStore(r repo.Repo) (datastore.Datastore, format.DAGService, format.DAGService, error) {
-	dstr := r.Datastore()
+func makeStore(dstr datastore.Batching) (datastore.Datastore, format.DAGService, format.DAGService, error) {
 	dstore := &batchWrap{dstr}
 
 	bstore := blockstore.NewBlockstore(dstr)
@@ -196,10 +232,10 @@ func makeStore(r repo.Repo) (datastore.Datastore, format.DAGService, format.DAGS
 	return dstore, syncDs, syncInternalDag, nil
 }
 
-func transferPins(ctx context.Context, r repo.Repo) error {
-	log.Print("upgrading pinning to use datastore")
+func transferPins(ctx context.Context, ds datastore.Batching) error {
+	log.Log("upgrading pinning to use datastore")
 
-	dstore, dserv, internalDag, err := makeStore(r)
+	dstore, dserv, internalDag, err := makeStore(ds)
 	if err != nil {
 		return err
 	}
@@ -208,14 +244,14 @@ func transferPins(ctx context.Context, r repo.Repo) error {
 	if err != nil {
 		return errors.New("failed to convert ipld pin data into datastore")
 	}
-	log.Printf("converted %d pins from ipld storage into datastore", toDSCount)
+	log.Log("converted %d pins from ipld storage into datastore", toDSCount)
 	return nil
 }
 
-func revertPins(ctx context.Context, r repo.Repo) error {
-	log.Print("reverting pinning to use ipld storage")
+func revertPins(ctx context.Context, ds datastore.Batching) error {
+	log.Log("reverting pinning to use ipld storage")
 
-	dstore, dserv, internalDag, err := makeStore(r)
+	dstore, dserv, internalDag, err := makeStore(ds)
 	if err != nil {
 		return err
 	}
@@ -224,6 +260,6 @@ func revertPins(ctx context.Context, r repo.Repo) error {
 	if err != nil {
 		return errors.New("failed to convert pin data from datastore to ipld pinner")
 	}
-	log.Printf("converted %d pins from datastore to ipld storage", toIPLDCount)
+	log.Log("converted %d pins from datastore to ipld storage", toIPLDCount)
 	return nil
 }
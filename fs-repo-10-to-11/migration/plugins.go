@@ -0,0 +1,32 @@
+//go:build plugins
+// +build plugins
+
+package mg10
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-ipfs/plugin/loader"
+)
+
+// loadPlugins loads the go-ipfs plugins, preloaded and those of the repo at
+// repoPath, and injects them. build-plugin.sh builds with the plugins tag, so
+// that the plugins it bundles into go-ipfs are loaded.
+func loadPlugins(repoPath string) error {
+	// Load any external plugins if available in the repo
+	plugins, err := loader.NewPluginLoader(repoPath)
+	if err != nil {
+		return fmt.Errorf("error loading plugins: %s", err)
+	}
+
+	// Load preloaded and external plugins
+	if err := plugins.Initialize(); err != nil {
+		return fmt.Errorf("error initializing plugins: %s", err)
+	}
+
+	if err := plugins.Inject(); err != nil {
+		return fmt.Errorf("error initializing plugins: %s", err)
+	}
+
+	return nil
+}
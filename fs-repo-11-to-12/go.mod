@@ -3,16 +3,23 @@ module github.com/ipfs/fs-repo-migrations/fs-repo-11-to-12
 go 1.15
 
 require (
+	github.com/dustin/go-humanize v1.0.0
 	github.com/hsanjuan/ipfs-lite v1.1.19
 	github.com/ipfs/fs-repo-migrations/tools v0.0.0-20211209222258-754a2dcb82ea
 	github.com/ipfs/go-cid v0.0.7
 	github.com/ipfs/go-datastore v0.4.5
-	github.com/ipfs/go-ds-badger v0.2.7-0.20220117180822-159330558612 // indirect
+	github.com/ipfs/go-ds-badger v0.2.7-0.20220117180822-159330558612
 	github.com/ipfs/go-ds-flatfs v0.4.5
+	github.com/ipfs/go-ds-leveldb v0.4.2
 	github.com/ipfs/go-filestore v1.0.0
 	github.com/ipfs/go-ipfs v0.8.0
 	github.com/ipfs/go-ipfs-ds-help v1.0.0
 	github.com/ipfs/go-ipfs-pinner v0.1.1
 	github.com/ipfs/go-ipld-format v0.2.0
+	github.com/klauspost/compress v1.11.7
+	github.com/multiformats/go-multihash v0.0.15
 	github.com/otiai10/copy v1.5.1
+	github.com/syndtr/goleveldb v1.0.0
 )
+
+replace github.com/ipfs/fs-repo-migrations/tools => ../tools
@@ -0,0 +1,187 @@
+package mg11
+
+import (
+	"strings"
+
+	dsspec "github.com/ipfs/fs-repo-migrations/tools/dsspec"
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
+	ds "github.com/ipfs/go-datastore"
+	badgerds "github.com/ipfs/go-ds-badger"
+	"github.com/syndtr/goleveldb/leveldb"
+	ldbopts "github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Datastore types with their own swappers.
+const (
+	flatfsType = "flatfs"
+	badgerType = "badgerds"
+	leveldType = "levelds"
+)
+
+// batchBytes returns how many bytes of values are written in a batch by
+// swapWorkerBatch. For badger, a batch is kept within half a value log
+// file, so that the values of the old keys of a batch end up in few files,
+// which the value log GC can then drop as a whole.
+func batchBytes(b *dsspec.Backend) uint64 {
+	if b == nil || b.Type != badgerType {
+		return SyncSize
+	}
+	vlog := uint64(badgerds.DefaultOptions.ValueLogFileSize)
+	if s, ok := b.Spec["vlogFileSize"].(string); ok {
+		if n, err := parseBytes(s); err == nil && n > 0 {
+			vlog = n
+		}
+	}
+	if vlog/2 < SyncSize {
+		return vlog / 2
+	}
+	return SyncSize
+}
+
+// swapWorkerBatch swaps keys using the batches of the datastore, which for
+// leveldb and badger are written in bulk, by the backend. The new key is put
+// and the old one deleted in the same batch, so there is no need to sync
+// the copies before deleting the originals, as swapWorkerDS does. Reverting
+// keeps the old keys.
+func (cswap *CidSwapper) swapWorkerBatch(swapCh <-chan Swap, reverting bool, limit *rateLimiter) (uint64, uint64) {
+	var swapped, errored uint64
+	maxBytes := batchBytes(cswap.Blocks)
+
+	var batch ds.Batch
+	var pending []Swap
+	var size uint64
+	commit := func() error {
+		if batch == nil {
+			return nil
+		}
+		err := batch.Commit()
+		batch = nil
+		if err != nil {
+			return err
+		}
+		if err := cswap.Store.Sync(cswap.Prefix); err != nil {
+			return err
+		}
+		swapped += uint64(len(pending))
+		cswap.swapped(uint64(len(pending)))
+		if cswap.SwapCh != nil {
+			for _, sw := range pending {
+				cswap.SwapCh <- sw
+			}
+		}
+		log.Count("synced", swapped, "batch migration worker committed %d keys, %d in total", len(pending), swapped)
+		pending = pending[:0]
+		size = 0
+		return nil
+	}
+
+	for sw := range swapCh {
+		if reverting {
+			sw.Old, sw.New = sw.New, sw.Old
+		}
+
+		v, err := cswap.Store.Get(sw.Old)
+		// The datastore does not have the block we are planning to
+		// migrate.
+		if err == ds.ErrNotFound {
+			cswap.notFound(sw)
+			continue
+		} else if err != nil {
+			log.Error("swapping %s->%s: %s", sw.Old, sw.New, err)
+			errored++
+			continue
+		}
+		// The block is read and written again.
+		limit.wait(2 * uint64(len(v)))
+
+		if batch == nil {
+			if batch, err = cswap.Store.Batch(); err != nil {
+				log.Error("creating batch: %s", err)
+				errored++
+				break
+			}
+		}
+		err = batch.Put(sw.New, v)
+		if err == nil && !reverting {
+			err = batch.Delete(sw.Old)
+		}
+		if err != nil {
+			log.Error("swapping %s->%s: %s", sw.Old, sw.New, err)
+			errored++
+			continue
+		}
+		pending = append(pending, sw)
+
+		size += uint64(len(v))
+		if size >= maxBytes {
+			if err := commit(); err != nil {
+				log.Error("committing batch: %s", err)
+				errored++
+			}
+		}
+	}
+
+	if err := commit(); err != nil {
+		log.Error("committing last batch: %s", err)
+		errored++
+	}
+	// Drain the channel if the worker stopped early, so that the sender
+	// is not blocked.
+	for range swapCh {
+		errored++
+	}
+	return swapped, errored
+}
+
+// reclaim frees the space held by the old keys in the backend serving
+// /blocks, once they have all been swapped: badger runs its value log GC,
+// and leveldb compacts the range of the keys. Leveldb is opened on its own
+// for this, so the datastore is closed first. Failing to reclaim is only
+// warned about, as the migration itself is done.
+func (m *Migration) reclaim() {
+	if m.blocks == nil {
+		return
+	}
+
+	switch m.blocks.Type {
+	case badgerType:
+		gc, ok := m.dstore.(ds.GCDatastore)
+		if !ok {
+			return
+		}
+		log.Phase("  - collecting badger value log garbage")
+		if err := gc.CollectGarbage(); err != nil {
+			log.Warn("badger value log GC failed, space is reclaimed when go-ipfs runs it: %s", err)
+		}
+
+	case leveldType:
+		log.Phase("  - compacting leveldb")
+		m.dstore.Close()
+		m.dstore = nil
+		if err := compactLevelDB(m.blocks); err != nil {
+			log.Warn("leveldb compaction failed, space is reclaimed as leveldb compacts on its own: %s", err)
+		}
+	}
+}
+
+// compactLevelDB compacts the keys under /blocks in the leveldb backend b,
+// which must not be open.
+func compactLevelDB(b *dsspec.Backend) error {
+	opts := &ldbopts.Options{ErrorIfMissing: true}
+	switch b.Spec["compression"] {
+	case "none":
+		opts.Compression = ldbopts.NoCompression
+	case "snappy":
+		opts.Compression = ldbopts.SnappyCompression
+	}
+	db, err := leveldb.OpenFile(b.Path, opts)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Keys are stored without the mountpoint of the backend.
+	prefix := strings.TrimPrefix(blocksPrefix.String(), strings.TrimSuffix(b.Mountpoint, "/"))
+	return db.CompactRange(*util.BytesPrefix([]byte(prefix)))
+}
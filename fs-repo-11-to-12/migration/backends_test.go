@@ -0,0 +1,170 @@
+package mg11
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	dsspec "github.com/ipfs/fs-repo-migrations/tools/dsspec"
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestLevelDBMigration(t *testing.T) {
+	testMigrationBase(t, map[string]interface{}{
+		"type":        "levelds",
+		"path":        "blocksdb",
+		"compression": "none",
+	})
+}
+
+func TestBadgerMigration(t *testing.T) {
+	testMigrationBase(t, map[string]interface{}{
+		"type":       "badgerds",
+		"path":       "badgerds",
+		"syncWrites": false,
+		// Small value log files, so that several batches are
+		// committed.
+		"vlogFileSize": "1MiB",
+	})
+}
+
+// moveBlocks moves the blocks of the working repo from flatfs to the
+// datastore specified by child, which then serves /blocks.
+func moveBlocks(t *testing.T, child map[string]interface{}) {
+	t.Helper()
+	opts := migrate.Options{Flags: migrate.Flags{Path: workingRepo}}
+	m := Migration{}
+	if err := m.open(opts); err != nil {
+		t.Fatal(err)
+	}
+	results, err := m.dstore.Query(query.Query{Prefix: blocksPrefix.String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := results.Rest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.dstore.Close()
+	m.dstore = nil
+	if len(entries) == 0 {
+		t.Fatal("test repo has no blocks")
+	}
+
+	configPath := filepath.Join(workingRepo, "config")
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	spec, err := dsspec.FromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mount := range spec["mounts"].([]interface{}) {
+		mount := mount.(map[string]interface{})
+		if mount["mountpoint"] == blocksPrefix.String() {
+			mount["child"] = child
+		}
+	}
+	if data, err = json.MarshalIndent(cfg, "", "  "); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	diskSpec, err := dsspec.DiskSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(workingRepo, "datastore_spec"), []byte(dsspec.String(diskSpec)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(workingRepo, "blocks")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.open(opts); err != nil {
+		t.Fatal(err)
+	}
+	defer m.dstore.Close()
+	for _, e := range entries {
+		if err := m.dstore.Put(ds.NewKey(e.Key), e.Value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestBatchBytes(t *testing.T) {
+	cases := []struct {
+		name    string
+		backend *dsspec.Backend
+		want    uint64
+	}{
+		{"none", nil, SyncSize},
+		{"flatfs", &dsspec.Backend{Type: flatfsType}, SyncSize},
+		{"leveldb", &dsspec.Backend{Type: leveldType}, SyncSize},
+		{"badger", &dsspec.Backend{Type: badgerType, Spec: map[string]interface{}{}}, SyncSize},
+		{"badger small vlog", &dsspec.Backend{Type: badgerType, Spec: map[string]interface{}{"vlogFileSize": "64MiB"}}, 32 << 20},
+		{"badger large vlog", &dsspec.Backend{Type: badgerType, Spec: map[string]interface{}{"vlogFileSize": "1GiB"}}, SyncSize},
+		{"badger bad vlog", &dsspec.Backend{Type: badgerType, Spec: map[string]interface{}{"vlogFileSize": "lots"}}, SyncSize},
+	}
+	for _, c := range cases {
+		if got := batchBytes(c.backend); got != c.want {
+			t.Errorf("%s: %d bytes per batch, expected %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCompactLevelDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "compact-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b := &dsspec.Backend{Type: leveldType, Mountpoint: "/", Path: filepath.Join(dir, "datastore"), Spec: map[string]interface{}{"compression": "none"}}
+	if err := compactLevelDB(b); err == nil {
+		t.Fatal("expected an error compacting a missing leveldb")
+	}
+
+	db, err := leveldb.OpenFile(b.Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := make([]byte, 4096)
+	for _, k := range []string{"/blocks/A", "/blocks/B", "/blocks/C", "/local/filesroot"} {
+		if err := db.Put([]byte(k), value, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, k := range []string{"/blocks/A", "/blocks/B"} {
+		if err := db.Delete([]byte(k), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	if err := compactLevelDB(b); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = leveldb.OpenFile(b.Path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for k, want := range map[string]bool{"/blocks/A": false, "/blocks/B": false, "/blocks/C": true, "/local/filesroot": true} {
+		if has, err := db.Has([]byte(k), nil); err != nil || has != want {
+			t.Errorf("%s: has %t (%v) after compacting, expected %t", k, has, err, want)
+		}
+	}
+}
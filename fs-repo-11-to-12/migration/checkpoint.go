@@ -0,0 +1,113 @@
+package mg11
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
+)
+
+const checkpointFile = "11-to-12-checkpoint.json"
+
+// checkpoint records how far Apply got, so that a run that was interrupted
+// is continued by the next one instead of starting over. It is written next
+// to the backup file and removed once the version is bumped.
+type checkpoint struct {
+	// Prepared lists the prefixes whose CIDv1 keys are all in the backup
+	// file.
+	Prepared []string `json:"prepared"`
+	// LogSize is the size of the backup file when the last prefix was
+	// prepared. Anything after it was written by an interrupted Prepare,
+	// and is dropped so that the keys are not logged twice.
+	LogSize int64 `json:"log_size"`
+	// Sealed is set once the trailer of the backup file is written after
+	// the last prefix was prepared.
+	Sealed bool `json:"sealed,omitempty"`
+	// Swapped is the offset of the frame in the backup file before which
+	// every key has been swapped and synced.
+	Swapped int64 `json:"swapped"`
+	// Converted is the size of the backup file after the keys of a text
+	// backup file of an older version were converted into it. Prepare
+	// does not add the keys before it again.
+	Converted int64 `json:"converted,omitempty"`
+
+	path string
+}
+
+// loadCheckpoint reads the checkpoint of the repo at repoPath. It returns an
+// empty checkpoint if there is none. The checkpoint is only trusted if the
+// backup file it describes is there and at least as long as recorded.
+func loadCheckpoint(repoPath string) (*checkpoint, error) {
+	cp := &checkpoint{path: filepath.Join(repoPath, checkpointFile)}
+	data, err := ioutil.ReadFile(cp.path)
+	if os.IsNotExist(err) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("reading %s: %s", checkpointFile, err)
+	}
+
+	fi, err := os.Stat(filepath.Join(repoPath, backupFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err != nil || fi.Size() < cp.LogSize || cp.Swapped > cp.LogSize {
+		log.Warn("%s does not match %s, starting over", checkpointFile, backupFile)
+		return &checkpoint{path: cp.path}, nil
+	}
+	return cp, nil
+}
+
+// started returns whether an earlier run recorded any progress.
+func (cp *checkpoint) started() bool {
+	return len(cp.Prepared) != 0
+}
+
+// prepared returns whether every key of prefix is in the backup file.
+func (cp *checkpoint) prepared(prefix string) bool {
+	for _, p := range cp.Prepared {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the checkpoint to a temporary file and renames it into place,
+// so that the checkpoint on disk is always complete.
+func (cp *checkpoint) save() error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := cp.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(data, '\n')); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, cp.path)
+}
+
+func (cp *checkpoint) remove() error {
+	err := os.Remove(cp.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package mg11
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/fs-repo-migrations/fs-repo-11-to-12/migration/cidlog"
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+	mfsr "github.com/ipfs/fs-repo-migrations/tools/mfsr"
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
+	ds "github.com/ipfs/go-datastore"
+)
+
+// TestResumeAfterPrepare interrupts Apply once the keys of the first prefix
+// are in the backup file, and checks that running it again finishes the
+// migration without logging any key twice.
+func TestResumeAfterPrepare(t *testing.T) {
+	testResume(t, func(t *testing.T, m *Migration, cp *checkpoint, hdr cidlog.Header) {
+		backupPath := filepath.Join(workingRepo, backupFile)
+		if err := m.prepareBackup(backupPath, hdr, cp, 0, migrationPrefixes[0]); err != nil {
+			t.Fatal(err)
+		}
+		// Keys of the next prefix written after the checkpoint are
+		// dropped when resuming.
+		w, err := cidlog.Append(backupPath, hdr, cp.LogSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range v1Keys(t, m.dstore)[:1] {
+			w.Add(0, k)
+		}
+		w.EndPrefix()
+		w.Close()
+	})
+}
+
+// TestResumeMidSwap interrupts Apply half way through swapping the keys,
+// after a chunk of them was swapped but before the checkpoint recorded it,
+// and checks that running it again swaps the rest without reporting the
+// swapped keys as missing.
+func TestResumeMidSwap(t *testing.T) {
+	testResume(t, func(t *testing.T, m *Migration, cp *checkpoint, hdr cidlog.Header) {
+		backupPath := filepath.Join(workingRepo, backupFile)
+		for i, prefix := range migrationPrefixes {
+			if err := m.prepareBackup(backupPath, hdr, cp, i, prefix); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := cidlog.Seal(backupPath, cidlog.StatePrepared); err != nil {
+			t.Fatal(err)
+		}
+		cp.Sealed = true
+		if err := cp.save(); err != nil {
+			t.Fatal(err)
+		}
+
+		l, err := cidlog.Open(backupPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		entries, err := l.Next(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		swaps := swapsOf(entries)
+		half := swaps[:(len(swaps)+1)/2]
+		cidSwapper := CidSwapper{Store: m.dstore, Blocks: m.blocks}
+		if _, err := cidSwapper.Run(sendSwaps(half)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// TestResumeFromText interrupts Apply of an older version, which wrote the
+// backup file as text and may have logged keys twice, and checks that
+// running it again converts the backup file and finishes the migration.
+func TestResumeFromText(t *testing.T) {
+	testResume(t, func(t *testing.T, m *Migration, cp *checkpoint, hdr cidlog.Header) {
+		var text bytes.Buffer
+		for _, k := range v1Keys(t, m.dstore) {
+			fmt.Fprintf(&text, "%s\n%s\n", k, k)
+		}
+		backupPath := filepath.Join(workingRepo, backupFile)
+		if err := ioutil.WriteFile(backupPath, text.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if isLog, err := cidlog.IsCIDLog(backupPath); err != nil || isLog {
+			t.Fatalf("text backup file taken for a CID log: %v", err)
+		}
+	})
+}
+
+// testResume copies the test repo, runs interrupt on it as an interrupted
+// Apply would have left it, and applies the migration.
+func testResume(t *testing.T, interrupt func(*testing.T, *Migration, *checkpoint, cidlog.Header)) {
+	var errs bytes.Buffer
+	errOut := log.ErrOut
+	log.ErrOut = &errs
+	defer func() { log.ErrOut = errOut }()
+
+	copyRepo(t)
+	opts := migrate.Options{
+		Verbose: true,
+		Flags: migrate.Flags{
+			Path:    workingRepo,
+			Verbose: true,
+		},
+	}
+	m := Migration{}
+	if err := m.open(opts); err != nil {
+		t.Fatal(err)
+	}
+	want := v1Keys(t, m.dstore)
+	if len(want) == 0 {
+		t.Fatal("test repo has no CIDv1 keys")
+	}
+
+	cp, err := loadCheckpoint(workingRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr, err := startLog(workingRepo, cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupt(t, &m, cp, hdr)
+	m.dstore.Close()
+
+	if err := m.Apply(opts); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(errs.String(), "Could not find") {
+		t.Errorf("swapped keys reported as missing:\n%s", errs.String())
+	}
+
+	version, err := mfsr.RepoPath(workingRepo).Version()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "12" {
+		t.Fatalf("repo at version %s, want 12", version)
+	}
+
+	backupPath := filepath.Join(workingRepo, backupFile)
+	hdr, err = newLogHeader(workingRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, problems, err := cidlog.Check(backupPath, hdr.PeerID); err != nil || len(problems) != 0 {
+		t.Fatalf("backup file: %v %q", err, problems)
+	}
+	l, err := cidlog.Open(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	logged := make(map[ds.Key]int)
+	for {
+		entries, err := l.Next(swapChunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, e := range entries {
+			logged[e.Key]++
+		}
+	}
+	for _, k := range want {
+		if logged[k] != 1 {
+			t.Errorf("%s is in the backup file %d times, want 1", k, logged[k])
+		}
+	}
+	if len(logged) != len(want) {
+		t.Errorf("%d keys in the backup file, want %d", len(logged), len(want))
+	}
+
+	if err := m.open(opts); err != nil {
+		t.Fatal(err)
+	}
+	defer m.dstore.Close()
+	if left := v1Keys(t, m.dstore); len(left) != 0 {
+		t.Errorf("CIDv1 keys left after the migration: %s", left)
+	}
+	for _, sw := range swapsOf(entriesOf(want)) {
+		if has, err := m.dstore.Has(sw.New); err != nil || !has {
+			t.Errorf("%s was not swapped to %s", sw.Old, sw.New)
+		}
+	}
+}
+
+// v1Keys returns the CIDv1 keys in the migrated prefixes of dstore.
+func v1Keys(t *testing.T, dstore ds.Batching) []ds.Key {
+	t.Helper()
+	var keys []ds.Key
+	for _, prefix := range migrationPrefixes {
+		cidSwapper := CidSwapper{Prefix: prefix, Store: dstore, SwapCh: make(chan Swap, 1000)}
+		done := make(chan struct{})
+		go func() {
+			for sw := range cidSwapper.SwapCh {
+				keys = append(keys, sw.Old)
+			}
+			close(done)
+		}()
+		_, err := cidSwapper.Prepare()
+		close(cidSwapper.SwapCh)
+		<-done
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return keys
+}
+
+// entriesOf returns the backup file entries of the CIDv1 keys.
+func entriesOf(keys []ds.Key) []cidlog.Entry {
+	var entries []cidlog.Entry
+	for _, k := range keys {
+		c, err := dsKeyToCid(ds.NewKey(k.BaseNamespace()))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, cidlog.Entry{Key: k, CID: c.Bytes()})
+	}
+	return entries
+}
@@ -0,0 +1,65 @@
+package mg11
+
+import (
+	"os"
+	"time"
+
+	"github.com/ipfs/fs-repo-migrations/fs-repo-11-to-12/migration/cidlog"
+	dsspec "github.com/ipfs/fs-repo-migrations/tools/dsspec"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+)
+
+// The backup file, in the format of package cidlog, lists the CIDv1 keys
+// swapped by Apply, so that Revert can swap them back. Older versions of the
+// migration wrote it as text under the same name; cidlog tells the formats
+// apart by their first bytes, so a backup file of theirs is read by Revert,
+// and converted by Apply to continue an interrupted run.
+const backupFile = "11-to-12-cids.txt"
+
+// LogCompression is how the keys in the backup file are compressed, "none"
+// or "zstd". It is set by the -log-compression flag.
+var LogCompression = cidlog.CompressionNone
+
+// newLogHeader returns the header of a new backup file for the repo at
+// repoPath.
+func newLogHeader(repoPath string) (cidlog.Header, error) {
+	cfg, err := fsrepo.ConfigAt(repoPath)
+	if err != nil {
+		return cidlog.Header{}, err
+	}
+	hdr := cidlog.Header{
+		Version:     cidlog.Version,
+		Migration:   "11-to-12",
+		PeerID:      cfg.Identity.PeerID,
+		Compression: LogCompression,
+		Created:     time.Now().UTC(),
+	}
+	spec, err := dsspec.Read(repoPath)
+	if err != nil && !os.IsNotExist(err) {
+		return cidlog.Header{}, err
+	}
+	if err == nil {
+		hdr.DatastoreSpec = dsspec.String(spec)
+	}
+	for _, p := range migrationPrefixes {
+		hdr.Prefixes = append(hdr.Prefixes, p.String())
+	}
+	return hdr, nil
+}
+
+// swapsOf returns the swaps of the CIDv1 keys in entries to their multihash
+// keys.
+func swapsOf(entries []cidlog.Entry) []Swap {
+	swaps := make([]Swap, len(entries))
+	for i, e := range entries {
+		// The swapper will move the CIDv1 key to the multihash
+		// key, and the unswapper will do the opposite.
+		mhashPath := e.Key
+		if c, err := dsKeyToCid(dshelp.NewKeyFromBinary(e.CID)); err == nil {
+			mhashPath = e.Key.Parent().Child(dshelp.MultihashToDsKey(c.Hash()))
+		}
+		swaps[i] = Swap{Old: e.Key, New: mhashPath}
+	}
+	return swaps
+}
@@ -0,0 +1,886 @@
+// Package cidlog reads and writes the CID log of the 11-to-12 migration: the
+// list of CIDv1 keys swapped by Apply, so that Revert can swap them back.
+//
+// It was a plain text file with one key per line; logs of that version are
+// still read. The current version is made of frames:
+//
+//	magic "\x89CIDLOG\r\n"
+//	frame 'H': the header, in JSON (Header)
+//	frame 'K': keys, in one prefix
+//	frame 'P': the end of the keys of a prefix, once Prepare is done with it
+//	frame 'L': the end of keys kept from a text log of an interrupted Apply
+//	frame 'T': the trailer, in JSON (Trailer)
+//
+// A frame is its type, the length of its payload as a uvarint, the payload,
+// and the CRC-32C of the type and payload, big endian. A 'K' payload is the
+// index of the prefix in the header and the number of keys, as uvarints,
+// followed by each key as a uvarint length and the binary CID, which are
+// compressed with zstd if the header says so. 'P' and 'L' payloads are
+// the number of keys since the previous 'P' or 'L' frame, as a uvarint. The
+// trailer holds the number of keys of each prefix and the SHA-256 of
+// everything before it.
+package cidlog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	magic = "\x89CIDLOG\r\n"
+	// Version is the version of the format; 1 is the text one.
+	Version = 2
+)
+
+// Frame types.
+const (
+	frameHeader     = 'H'
+	frameKeys       = 'K'
+	framePrepared   = 'P'
+	frameLegacy     = 'L'
+	frameTrailer    = 'T'
+	keysPerFrame    = 4096
+	maxFramePayload = 64 << 20
+)
+
+// Compression of the keys in a CID log.
+const (
+	CompressionNone = "none"
+	CompressionZstd = "zstd"
+)
+
+// States of a CID log in its trailer.
+const (
+	StatePrepared = "prepared" // every key to swap is in the log
+	StateApplied  = "applied"  // and they have all been swapped
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Header identifies the repo and the migration a CID log belongs to.
+type Header struct {
+	Version   int    `json:"version"`
+	Migration string `json:"migration"`
+	// PeerID is the identity of the repo in its config.
+	PeerID string `json:"peer_id"`
+	// DatastoreSpec is the datastore_spec of the repo.
+	DatastoreSpec string    `json:"datastore_spec,omitempty"`
+	Prefixes      []string  `json:"prefixes"`
+	Compression   string    `json:"compression"`
+	Created       time.Time `json:"created"`
+}
+
+// Trailer ends a complete CID log.
+type Trailer struct {
+	State string `json:"state"`
+	// Counts is the number of keys of each prefix.
+	Counts map[string]uint64 `json:"counts"`
+	Total  uint64            `json:"total"`
+	// SHA256 is the hex SHA-256 of the log before the trailer.
+	SHA256 string `json:"sha256"`
+}
+
+// ErrNotCIDLog is returned by ScanFile for a log in the text format.
+var ErrNotCIDLog = errors.New("not a CID log")
+
+// Entry is a key in a CID log.
+type Entry struct {
+	// Key is the CIDv1 key, under its prefix.
+	Key ds.Key
+	// CID is the binary CID of the key.
+	CID []byte
+}
+
+// writeFrame writes a frame and returns its size.
+func writeFrame(w io.Writer, typ byte, payload []byte) (int64, error) {
+	var hdr [1 + binary.MaxVarintLen64]byte
+	hdr[0] = typ
+	n := 1 + binary.PutUvarint(hdr[1:], uint64(len(payload)))
+	crc := crc32.Update(crc32.Checksum(hdr[:1], crcTable), crcTable, payload)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc)
+	for _, b := range [][]byte{hdr[:n], payload, sum[:]} {
+		if _, err := w.Write(b); err != nil {
+			return 0, err
+		}
+	}
+	return int64(n + len(payload) + len(sum)), nil
+}
+
+// countingReader counts and optionally hashes the bytes read through it.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+	h hash.Hash
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+		if c.h != nil {
+			c.h.Write([]byte{b})
+		}
+	}
+	return b, err
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.h != nil {
+		c.h.Write(p[:n])
+	}
+	return n, err
+}
+
+// readFrame reads a frame. It returns io.EOF at the end of the log, and an
+// error saying what is wrong with a torn or corrupt frame.
+func readFrame(r *countingReader) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("truncated frame")
+	}
+	if size > maxFramePayload {
+		return 0, nil, fmt.Errorf("frame of %d bytes is too large", size)
+	}
+	payload := make([]byte, size+4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, fmt.Errorf("truncated frame")
+	}
+	payload, sum := payload[:size], payload[size:]
+	crc := crc32.Update(crc32.Checksum([]byte{typ}, crcTable), crcTable, payload)
+	if binary.BigEndian.Uint32(sum) != crc {
+		return 0, nil, fmt.Errorf("checksum mismatch in %q frame", typ)
+	}
+	return typ, payload, nil
+}
+
+// IsCIDLog returns whether the file at path is in the current format, as
+// opposed to the text one.
+func IsCIDLog(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	b := make([]byte, len(magic))
+	n, err := io.ReadFull(f, b)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false, err
+	}
+	return string(b[:n]) == magic, nil
+}
+
+// Create writes a CID log with only a header to path, replacing any file
+// there, and returns its size.
+func Create(path string, hdr Header) (int64, error) {
+	hdr.Version = Version
+	payload, err := json.Marshal(hdr)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err = io.WriteString(f, magic); err != nil {
+		return 0, err
+	}
+	n, err := writeFrame(f, frameHeader, payload)
+	if err != nil {
+		return 0, err
+	}
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+	return int64(len(magic)) + n, f.Close()
+}
+
+// Writer appends the keys of a prefix to a CID log.
+type Writer struct {
+	f      *os.File
+	buf    *bufio.Writer
+	enc    *zstd.Encoder
+	size   int64
+	prefix uint64
+
+	body  []byte
+	keys  uint64
+	count uint64
+}
+
+// Append opens the CID log at path, whose header is hdr, for appending keys,
+// after dropping whatever is past size.
+func Append(path string, hdr Header, size int64) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = f.Truncate(size); err == nil {
+		_, err = f.Seek(size, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	w := &Writer{f: f, buf: bufio.NewWriter(f), size: size}
+	if hdr.Compression == CompressionZstd {
+		if w.enc, err = zstd.NewWriter(nil); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// Add adds key, which must be in the prefix at index prefix in the header.
+func (w *Writer) Add(prefix int, key ds.Key) error {
+	b, err := dshelp.BinaryFromDsKey(ds.NewKey(key.BaseNamespace()))
+	if err != nil {
+		return err
+	}
+	if uint64(prefix) != w.prefix || w.keys == keysPerFrame {
+		if err := w.flushKeys(); err != nil {
+			return err
+		}
+		w.prefix = uint64(prefix)
+	}
+	w.body = appendUvarint(w.body, uint64(len(b)))
+	w.body = append(w.body, b...)
+	w.keys++
+	w.count++
+	return nil
+}
+
+func (w *Writer) flushKeys() error {
+	if w.keys == 0 {
+		return nil
+	}
+	body := w.body
+	if w.enc != nil {
+		body = w.enc.EncodeAll(body, nil)
+	}
+	payload := appendUvarint(appendUvarint(nil, w.prefix), w.keys)
+	n, err := writeFrame(w.buf, frameKeys, append(payload, body...))
+	if err != nil {
+		return err
+	}
+	w.size += n
+	w.body = w.body[:0]
+	w.keys = 0
+	return nil
+}
+
+// EndPrefix writes the keys added so far followed by the end of their
+// prefix, syncs the log, and returns its size.
+func (w *Writer) EndPrefix() (int64, error) {
+	return w.end(framePrepared)
+}
+
+// EndLegacy is EndPrefix for the keys of a text log, which may be of several
+// prefixes.
+func (w *Writer) EndLegacy() (int64, error) {
+	return w.end(frameLegacy)
+}
+
+func (w *Writer) end(typ byte) (int64, error) {
+	if err := w.flushKeys(); err != nil {
+		return 0, err
+	}
+	n, err := writeFrame(w.buf, typ, appendUvarint(nil, w.count))
+	if err != nil {
+		return 0, err
+	}
+	w.size += n
+	w.count = 0
+	if err := w.buf.Flush(); err != nil {
+		return 0, err
+	}
+	return w.size, w.f.Sync()
+}
+
+func (w *Writer) Close() error {
+	if w.enc != nil {
+		w.enc.Close()
+	}
+	return w.f.Close()
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// Reader reads the keys in a CID log, of either format.
+type Reader struct {
+	f      *os.File
+	r      *countingReader
+	legacy bool
+	header Header
+	dec    *zstd.Decoder
+	// data is the offset of the first frame after the header.
+	data int64
+	// trailer is the offset of the trailer, once Next has reached it.
+	trailer int64
+}
+
+// Open opens the CID log at path and reads its header.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &Reader{f: f, r: &countingReader{r: bufio.NewReader(f)}}
+	b, err := l.r.r.Peek(len(magic))
+	if (err != nil && err != io.EOF) || string(b) != magic {
+		if err != nil && err != io.EOF {
+			f.Close()
+			return nil, err
+		}
+		l.legacy = true
+		return l, nil
+	}
+	l.r.Read(make([]byte, len(magic)))
+
+	typ, payload, err := readFrame(l.r)
+	if err == nil && typ != frameHeader {
+		err = fmt.Errorf("first frame is %q, not a header", typ)
+	}
+	if err == nil {
+		err = json.Unmarshal(payload, &l.header)
+	}
+	if err == nil && l.header.Version != Version {
+		err = fmt.Errorf("unsupported version %d", l.header.Version)
+	}
+	if err == nil && l.header.Compression == CompressionZstd {
+		l.dec, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxFramePayload))
+	} else if err == nil && l.header.Compression != CompressionNone {
+		err = fmt.Errorf("unknown compression %q", l.header.Compression)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading header of CID log: %s", err)
+	}
+	l.data = l.r.n
+	return l, nil
+}
+
+// Legacy returns whether the log is in the text format, which has no header.
+func (l *Reader) Legacy() bool {
+	return l.legacy
+}
+
+// Header returns the header of the log.
+func (l *Reader) Header() Header {
+	return l.header
+}
+
+// SetOffset moves to offset off, which must be a frame boundary, or the start of
+// a line in a text log. Offsets before the first frame move to it.
+func (l *Reader) SetOffset(off int64) error {
+	if off < l.data {
+		off = l.data
+	}
+	if _, err := l.f.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	l.r = &countingReader{r: bufio.NewReader(l.f), n: off}
+	l.trailer = 0
+	return nil
+}
+
+// Offset returns the offset of the next frame, or line. It stays at the
+// trailer once it is reached.
+func (l *Reader) Offset() int64 {
+	if l.trailer != 0 {
+		return l.trailer
+	}
+	return l.r.n
+}
+
+// Next returns the keys in the next frames, or lines, until there are at
+// least max of them, or none if the log is done. It stops at a frame
+// boundary.
+func (l *Reader) Next(max int) ([]Entry, error) {
+	if l.legacy {
+		return l.nextLines(max)
+	}
+	var entries []Entry
+	for len(entries) < max && l.trailer == 0 {
+		start := l.r.n
+		typ, payload, err := readFrame(l.r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("at offset %d: %s", start, err)
+		}
+		if typ == frameTrailer {
+			l.trailer = start
+			break
+		}
+		if typ != frameKeys {
+			continue
+		}
+		prefix, keys, err := l.decodeKeys(payload)
+		if err != nil {
+			return nil, fmt.Errorf("at offset %d: %s", start, err)
+		}
+		for _, k := range keys {
+			entries = append(entries, Entry{Key: prefix.Child(dshelp.NewKeyFromBinary(k)), CID: k})
+		}
+	}
+	return entries, nil
+}
+
+// keysHeader decodes the prefix index and number of keys of a 'K' payload,
+// and returns the rest of it.
+func keysHeader(payload []byte) (prefix, n uint64, rest []byte, err error) {
+	r := bytes.NewReader(payload)
+	if prefix, err = binary.ReadUvarint(r); err == nil {
+		n, err = binary.ReadUvarint(r)
+	}
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("bad keys frame")
+	}
+	return prefix, n, payload[len(payload)-r.Len():], nil
+}
+
+// decodeKeys returns the prefix and binary CIDs of a 'K' payload.
+func (l *Reader) decodeKeys(payload []byte) (ds.Key, [][]byte, error) {
+	idx, n, body, err := keysHeader(payload)
+	if err != nil {
+		return ds.Key{}, nil, err
+	}
+	if idx >= uint64(len(l.header.Prefixes)) {
+		return ds.Key{}, nil, fmt.Errorf("keys frame for unknown prefix %d", idx)
+	}
+	if l.dec != nil {
+		if body, err = l.dec.DecodeAll(body, nil); err != nil {
+			return ds.Key{}, nil, fmt.Errorf("decompressing keys: %s", err)
+		}
+	}
+	keys := make([][]byte, 0, n)
+	for len(body) > 0 {
+		size, m := binary.Uvarint(body)
+		if m <= 0 || uint64(len(body)-m) < size {
+			return ds.Key{}, nil, fmt.Errorf("bad key in keys frame")
+		}
+		keys = append(keys, body[m:m+int(size)])
+		body = body[m+int(size):]
+	}
+	if uint64(len(keys)) != n {
+		return ds.Key{}, nil, fmt.Errorf("keys frame has %d keys, not %d", len(keys), n)
+	}
+	return ds.NewKey(l.header.Prefixes[idx]), keys, nil
+}
+
+// nextLines reads up to max keys from a text log.
+func (l *Reader) nextLines(max int) ([]Entry, error) {
+	var entries []Entry
+	for len(entries) < max {
+		line, err := l.r.r.ReadString('\n')
+		l.r.n += int64(len(line))
+		if err == io.EOF && len(line) == 0 {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if len(line) == 0 {
+			continue
+		}
+		// The text log only contains the original cidv1 path.
+		key := ds.NewKey(line)
+		kb, err := dshelp.BinaryFromDsKey(ds.NewKey(key.BaseNamespace()))
+		if err != nil {
+			return nil, fmt.Errorf("could not parse cid from CID log: %s", err)
+		}
+		entries = append(entries, Entry{Key: key, CID: kb})
+	}
+	return entries, nil
+}
+
+func (l *Reader) Close() error {
+	if l.dec != nil {
+		l.dec.Close()
+	}
+	return l.f.Close()
+}
+
+// Scan is what ScanFile finds in a CID log in the current format.
+type Scan struct {
+	Header  Header
+	Trailer *Trailer
+	// Prepared lists the prefixes whose end was recorded, in order.
+	Prepared []string
+	// Legacy is set if keys from a text log were kept.
+	Legacy bool
+	// Counts is the number of keys of each prefix, up to the last 'P' or
+	// 'L' frame.
+	Counts map[string]uint64
+	// Ended is the offset after the last 'P' or 'L' frame, or after the
+	// header if there is none.
+	Ended int64
+	// End is the offset of the trailer, or of the end of the valid frames
+	// if there is no trailer.
+	End int64
+	// Sum is the SHA-256 of the log before End.
+	Sum []byte
+	// Problem says why the frames stopped being valid before the end of
+	// the file, if they did.
+	Problem string
+}
+
+// ScanFile reads every frame of the CID log at path and checks them. Keys
+// are only decompressed and checked with checkKeys set. It returns
+// ErrNotCIDLog for a text log.
+func ScanFile(path string, checkKeys bool) (*Scan, error) {
+	l, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	if l.legacy {
+		return nil, ErrNotCIDLog
+	}
+
+	// Hash from the start, as the header was read already.
+	h := sha256.New()
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.CopyN(h, f, l.data)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	l.r.h = h
+
+	s := &Scan{Header: l.header, Counts: make(map[string]uint64), Ended: l.data}
+	pending := make(map[string]uint64)
+	var pendingTotal uint64
+	for {
+		start := l.r.n
+		sum := h.Sum(nil)
+		typ, payload, err := readFrame(l.r)
+		if err == io.EOF {
+			s.End, s.Sum = start, sum
+			break
+		}
+		if err != nil {
+			s.End, s.Sum = start, sum
+			s.Problem = fmt.Sprintf("at offset %d: %s", start, err)
+			break
+		}
+
+		switch typ {
+		case frameKeys:
+			idx, n, _, err := keysHeader(payload)
+			if err == nil && idx >= uint64(len(l.header.Prefixes)) {
+				err = fmt.Errorf("keys frame for unknown prefix %d", idx)
+			}
+			if err == nil && checkKeys {
+				_, _, err = l.decodeKeys(payload)
+			}
+			if err != nil {
+				s.End, s.Sum = start, sum
+				s.Problem = fmt.Sprintf("at offset %d: %s", start, err)
+				return s, nil
+			}
+			pending[l.header.Prefixes[idx]] += n
+			pendingTotal += n
+
+		case framePrepared, frameLegacy:
+			n, m := binary.Uvarint(payload)
+			if m <= 0 || n != pendingTotal {
+				s.End, s.Sum = start, sum
+				s.Problem = fmt.Sprintf("at offset %d: %q frame counts %d keys, but %d precede it", start, typ, n, pendingTotal)
+				return s, nil
+			}
+			if typ == framePrepared {
+				p := ""
+				for k := range pending {
+					p = k
+				}
+				if len(pending) > 1 {
+					s.End, s.Sum = start, sum
+					s.Problem = fmt.Sprintf("at offset %d: keys of several prefixes before the end of one", start)
+					return s, nil
+				}
+				if p == "" {
+					// A prefix with no CIDv1 keys; which one
+					// is not recorded, so use the next one.
+					p = nextPrefix(l.header.Prefixes, s.Prepared)
+				}
+				s.Prepared = append(s.Prepared, p)
+			} else {
+				s.Legacy = true
+			}
+			for k, n := range pending {
+				s.Counts[k] += n
+			}
+			pending, pendingTotal = make(map[string]uint64), 0
+			s.Ended = l.r.n
+
+		case frameTrailer:
+			var t Trailer
+			if err := json.Unmarshal(payload, &t); err != nil {
+				s.End, s.Sum = start, sum
+				s.Problem = fmt.Sprintf("at offset %d: bad trailer: %s", start, err)
+				return s, nil
+			}
+			s.Trailer = &t
+			s.End, s.Sum = start, sum
+			if _, err := l.r.ReadByte(); err != io.EOF {
+				s.Problem = fmt.Sprintf("data after the trailer at offset %d", l.r.n)
+			}
+			return s, nil
+
+		default:
+			s.End, s.Sum = start, sum
+			s.Problem = fmt.Sprintf("at offset %d: unknown frame type %q", start, typ)
+			return s, nil
+		}
+	}
+	return s, nil
+}
+
+// nextPrefix returns the first of prefixes that is not in done.
+func nextPrefix(prefixes, done []string) string {
+	for _, p := range prefixes {
+		found := false
+		for _, d := range done {
+			found = found || d == p
+		}
+		if !found {
+			return p
+		}
+	}
+	return ""
+}
+
+// Seal writes the trailer of the CID log at path, replacing the one there if
+// any, with state. Every prefix must have been prepared.
+func Seal(path, state string) error {
+	s, err := ScanFile(path, false)
+	if err != nil {
+		return err
+	}
+	if s.Problem != "" {
+		return fmt.Errorf("CID log is damaged %s", s.Problem)
+	}
+	if len(s.Prepared) != len(s.Header.Prefixes) {
+		return fmt.Errorf("CID log does not have the keys of every prefix")
+	}
+
+	t := Trailer{State: state, Counts: s.Counts, SHA256: hex.EncodeToString(s.Sum)}
+	for _, n := range s.Counts {
+		t.Total += n
+	}
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = f.Truncate(s.End); err == nil {
+		_, err = f.Seek(s.End, io.SeekStart)
+	}
+	if err == nil {
+		_, err = writeFrame(f, frameTrailer, payload)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CountKeys returns the number of keys in the CID log at path after offset
+// off, without decompressing them.
+func CountKeys(path string, off int64) (uint64, error) {
+	l, err := Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	if err := l.SetOffset(off); err != nil {
+		return 0, err
+	}
+
+	var n uint64
+	if l.legacy {
+		scanner := bufio.NewScanner(l.r.r)
+		for scanner.Scan() {
+			if len(scanner.Bytes()) != 0 {
+				n++
+			}
+		}
+		return n, scanner.Err()
+	}
+	for {
+		typ, payload, err := readFrame(l.r)
+		if err == io.EOF || typ == frameTrailer {
+			return n, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("at offset %d: %s", l.r.n, err)
+		}
+		if typ == frameKeys {
+			_, k, _, err := keysHeader(payload)
+			if err != nil {
+				return 0, err
+			}
+			n += k
+		}
+	}
+}
+
+// ConvertText writes the keys of the text CID log at path, left by an
+// interrupted Apply of an older version, to a new CID log at to, ending them
+// with an 'L' frame. Keys it holds more than once are dropped: those
+// versions appended every key again each time they were started. The new
+// log is written next to to and renamed into place once complete. It
+// returns the size of the new log and the number of keys dropped.
+func ConvertText(path, to string, hdr Header) (size int64, dups uint64, err error) {
+	tmp := to + ".tmp"
+	if size, err = Create(tmp, hdr); err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(tmp)
+	if size, dups, err = copyText(path, tmp, hdr, size); err != nil {
+		return 0, 0, err
+	}
+	return size, dups, os.Rename(tmp, to)
+}
+
+// copyText appends the keys of the text CID log at path to the CID log at
+// to, whose size is size, and returns its new size.
+func copyText(path, to string, hdr Header, size int64) (int64, uint64, error) {
+	l, err := Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer l.Close()
+	if !l.legacy {
+		return 0, 0, fmt.Errorf("%s is not a text CID log", path)
+	}
+	w, err := Append(to, hdr, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer w.Close()
+
+	index := make(map[string]int)
+	for i, p := range hdr.Prefixes {
+		index[p] = i
+	}
+	seen := make(map[string]struct{})
+	var dups uint64
+	for {
+		entries, err := l.Next(keysPerFrame)
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, e := range entries {
+			k := e.Key.String()
+			if _, ok := seen[k]; ok {
+				dups++
+				continue
+			}
+			seen[k] = struct{}{}
+			i, ok := index[e.Key.Parent().String()]
+			if !ok {
+				return 0, 0, fmt.Errorf("key %s is not in a migrated prefix", e.Key)
+			}
+			if err := w.Add(i, e.Key); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+	size, err = w.EndLegacy()
+	return size, dups, err
+}
+
+// Check verifies the CID log at path: its frames, the checksum and counts in
+// its trailer, and that it was written for the repo whose identity is
+// peerID. It returns its scan, and what is wrong with it. Text logs cannot
+// be verified, and have no scan.
+func Check(path, peerID string) (*Scan, []string, error) {
+	ok, err := IsCIDLog(path)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+	s, err := ScanFile(path, true)
+	if err != nil {
+		return nil, []string{err.Error()}, nil
+	}
+
+	var problems []string
+	if s.Problem != "" {
+		problems = append(problems, "damaged "+s.Problem)
+	}
+	if s.Header.PeerID != peerID {
+		problems = append(problems, fmt.Sprintf("written for repo %s, not this one, %s", s.Header.PeerID, peerID))
+	}
+	if s.Trailer == nil {
+		if s.Problem == "" {
+			problems = append(problems, "no trailer: Apply did not get to swapping keys")
+		}
+		return s, problems, nil
+	}
+	if sum := hex.EncodeToString(s.Sum); s.Trailer.SHA256 != sum {
+		problems = append(problems, fmt.Sprintf("checksum %s does not match the trailer, %s", sum, s.Trailer.SHA256))
+	}
+	if s.Ended != s.End {
+		problems = append(problems, fmt.Sprintf("keys at offset %d are not followed by the end of their prefix", s.Ended))
+	}
+	if len(s.Prepared) != len(s.Header.Prefixes) {
+		problems = append(problems, fmt.Sprintf("only %d of %d prefixes are complete", len(s.Prepared), len(s.Header.Prefixes)))
+	}
+	var total uint64
+	for _, p := range s.Header.Prefixes {
+		if s.Counts[p] != s.Trailer.Counts[p] {
+			problems = append(problems, fmt.Sprintf("%d keys in %s, but the trailer counts %d", s.Counts[p], p, s.Trailer.Counts[p]))
+		}
+		total += s.Counts[p]
+	}
+	if total != s.Trailer.Total {
+		problems = append(problems, fmt.Sprintf("%d keys in total, but the trailer counts %d", total, s.Trailer.Total))
+	}
+	return s, problems, nil
+}
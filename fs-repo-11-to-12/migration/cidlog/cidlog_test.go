@@ -0,0 +1,510 @@
+package cidlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const testPeerID = "QmTestPeer"
+
+var testPrefixes = []string{"/blocks", "/filestore"}
+
+func testHeader(compression string) Header {
+	return Header{
+		Migration:   "11-to-12",
+		PeerID:      testPeerID,
+		Prefixes:    testPrefixes,
+		Compression: compression,
+	}
+}
+
+// testKeys returns n CIDv1 keys under prefix.
+func testKeys(t *testing.T, prefix string, n int) []ds.Key {
+	t.Helper()
+	var keys []ds.Key
+	for i := 0; i < n; i++ {
+		hash, err := mh.Sum([]byte(fmt.Sprintf("%s %d", prefix, i)), mh.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := cid.NewCidV1(cid.Raw, hash)
+		keys = append(keys, ds.NewKey(prefix).Child(dshelp.NewKeyFromBinary(c.Bytes())))
+	}
+	return keys
+}
+
+// writeLog writes a CID log with keys of each prefix and returns its path
+// and size. It is sealed with state if that is set.
+func writeLog(t *testing.T, hdr Header, keys [][]ds.Key, state string) (string, int64) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cids.log")
+	size, err := Create(path, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, ks := range keys {
+		w, err := Append(path, hdr, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range ks {
+			if err := w.Add(i, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if size, err = w.EndPrefix(); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	if state != "" {
+		if err := Seal(path, state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return path, size
+}
+
+// readAll returns the keys of the log at path, reading max at a time.
+func readAll(t *testing.T, path string, max int) []string {
+	t.Helper()
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var keys []string
+	for {
+		entries, err := l.Next(max)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) == 0 {
+			return keys
+		}
+		for _, e := range entries {
+			keys = append(keys, e.Key.String())
+		}
+	}
+}
+
+func keyStrings(keys ...[]ds.Key) []string {
+	var s []string
+	for _, ks := range keys {
+		for _, k := range ks {
+			s = append(s, k.String())
+		}
+	}
+	return s
+}
+
+func equal(a, b []string) bool {
+	return strings.Join(a, "\n") == strings.Join(b, "\n")
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, compression := range []string{CompressionNone, CompressionZstd} {
+		t.Run(compression, func(t *testing.T) {
+			blocks := testKeys(t, "/blocks", 2*keysPerFrame+10)
+			filestore := testKeys(t, "/filestore", 3)
+			path, _ := writeLog(t, testHeader(compression), [][]ds.Key{blocks, filestore}, StateApplied)
+
+			if got, want := readAll(t, path, 100), keyStrings(blocks, filestore); !equal(got, want) {
+				t.Fatalf("read %d keys, want the %d written", len(got), len(want))
+			}
+			s, problems, err := Check(path, testPeerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(problems) != 0 {
+				t.Fatalf("problems with a good log: %q", problems)
+			}
+			if s.Header.Compression != compression {
+				t.Errorf("compression %q, want %q", s.Header.Compression, compression)
+			}
+			if s.Trailer.State != StateApplied || s.Trailer.Total != uint64(len(blocks)+len(filestore)) ||
+				s.Trailer.Counts["/blocks"] != uint64(len(blocks)) || s.Trailer.Counts["/filestore"] != uint64(len(filestore)) {
+				t.Errorf("bad trailer %+v", s.Trailer)
+			}
+			if n, err := CountKeys(path, 0); err != nil || n != uint64(len(blocks)+len(filestore)) {
+				t.Errorf("CountKeys = %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestEntries(t *testing.T) {
+	keys := testKeys(t, "/blocks", 1)
+	path, _ := writeLog(t, testHeader(CompressionNone), [][]ds.Key{keys, nil}, StatePrepared)
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	entries, err := l.Next(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("%d entries, want 1", len(entries))
+	}
+	c, err := cid.Cast(entries[0].CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries[0].Key != keys[0] || ds.NewKey("/blocks").Child(dshelp.NewKeyFromBinary(c.Bytes())) != keys[0] {
+		t.Errorf("entry %s, %s does not match key %s", entries[0].Key, c, keys[0])
+	}
+}
+
+// TestOffsets reads a log in parts, resuming from the offset of each, as
+// the swaps of an interrupted Apply do.
+func TestOffsets(t *testing.T) {
+	blocks := testKeys(t, "/blocks", 3*keysPerFrame)
+	path, _ := writeLog(t, testHeader(CompressionZstd), [][]ds.Key{blocks, nil}, StatePrepared)
+
+	var got []string
+	var off int64
+	for {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.SetOffset(off); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := l.Next(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := CountKeys(path, off); err != nil || n != uint64(len(blocks)-len(got)) {
+			t.Fatalf("CountKeys at %d = %d, %v, want %d", off, n, err, len(blocks)-len(got))
+		}
+		off = l.Offset()
+		l.Close()
+		if len(entries) == 0 {
+			break
+		}
+		if len(entries) != keysPerFrame {
+			t.Fatalf("read %d keys, want a frame of %d", len(entries), keysPerFrame)
+		}
+		for _, e := range entries {
+			got = append(got, e.Key.String())
+		}
+	}
+	if !equal(got, keyStrings(blocks)) {
+		t.Fatalf("read %d keys, want the %d written", len(got), len(blocks))
+	}
+	s, err := ScanFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != s.End {
+		t.Errorf("offset at the end is %d, want the trailer at %d", off, s.End)
+	}
+}
+
+// TestAppendDropsTail checks that appending after an interrupted Prepare
+// drops the keys written past the last end of a prefix.
+func TestAppendDropsTail(t *testing.T) {
+	hdr := testHeader(CompressionNone)
+	blocks := testKeys(t, "/blocks", 5)
+	path, size := writeLog(t, hdr, [][]ds.Key{blocks}, "")
+
+	w, err := Append(path, hdr, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range testKeys(t, "/filestore", 5) {
+		w.Add(1, k)
+	}
+	w.flushKeys()
+	w.buf.Flush()
+	w.Close()
+
+	s, err := ScanFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Ended != size || s.End == size {
+		t.Fatalf("keys after the end of the last prefix: ended %d, end %d, want %d and more", s.Ended, s.End, size)
+	}
+
+	filestore := testKeys(t, "/filestore", 2)
+	if w, err = Append(path, hdr, s.Ended); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range filestore {
+		w.Add(1, k)
+	}
+	if _, err := w.EndPrefix(); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := Seal(path, StatePrepared); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readAll(t, path, 10), keyStrings(blocks, filestore); !equal(got, want) {
+		t.Fatalf("got keys %q, want %q", got, want)
+	}
+	if _, problems, _ := Check(path, testPeerID); len(problems) != 0 {
+		t.Fatalf("problems: %q", problems)
+	}
+}
+
+// TestSealUnprepared checks that a log is only sealed once every prefix is
+// prepared.
+func TestSealUnprepared(t *testing.T) {
+	path, _ := writeLog(t, testHeader(CompressionNone), [][]ds.Key{testKeys(t, "/blocks", 1)}, "")
+	if err := Seal(path, StatePrepared); err == nil {
+		t.Fatal("sealed a log without the keys of every prefix")
+	}
+}
+
+// check returns the problems Check finds in the log at path, for peerID.
+func check(t *testing.T, path, peerID string) []string {
+	t.Helper()
+	_, problems, err := Check(path, peerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return problems
+}
+
+func hasProblem(problems []string, substr string) bool {
+	for _, p := range problems {
+		if strings.Contains(p, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTruncated(t *testing.T) {
+	keys := [][]ds.Key{testKeys(t, "/blocks", keysPerFrame+1), testKeys(t, "/filestore", 1)}
+	path, _ := writeLog(t, testHeader(CompressionNone), keys, StateApplied)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ScanFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		size int64
+		want string
+	}{
+		{"torn trailer", s.End + 3, "truncated frame"},
+		{"no trailer", s.End, "no trailer"},
+		{"torn keys", s.End / 2, "truncated frame"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ioutil.WriteFile(path, data[:tc.size], 0644); err != nil {
+				t.Fatal(err)
+			}
+			if problems := check(t, path, testPeerID); !hasProblem(problems, tc.want) {
+				t.Fatalf("problems %q, want %q", problems, tc.want)
+			}
+		})
+	}
+
+	// A log torn in its header cannot be read at all.
+	if err := ioutil.WriteFile(path, data[:len(magic)+5], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path); err == nil {
+		t.Fatal("opened a log with a torn header")
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	keys := [][]ds.Key{testKeys(t, "/blocks", 10), testKeys(t, "/filestore", 1)}
+	path, _ := writeLog(t, testHeader(CompressionNone), keys, StateApplied)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ScanFile(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A flipped bit in a key is caught by the CRC of its frame.
+	bad := append([]byte(nil), data...)
+	bad[s.End/2] ^= 1
+	if err := ioutil.WriteFile(path, bad, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if problems := check(t, path, testPeerID); !hasProblem(problems, "checksum mismatch") {
+		t.Fatalf("problems %q, want a checksum mismatch", problems)
+	}
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Next(100); err == nil {
+		t.Error("read the keys of a corrupt frame")
+	}
+	l.Close()
+
+	// A trailer whose SHA-256 does not match, with good frames.
+	if err := ioutil.WriteFile(path, data[:s.End], 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr := *s.Trailer
+	tr.SHA256 = strings.Repeat("0", 64)
+	writeTrailer(t, path, tr)
+	if problems := check(t, path, testPeerID); !hasProblem(problems, "does not match the trailer") {
+		t.Fatalf("problems %q, want a SHA-256 mismatch", problems)
+	}
+
+	// A trailer whose counts do not match.
+	if err := ioutil.WriteFile(path, data[:s.End], 0644); err != nil {
+		t.Fatal(err)
+	}
+	tr = *s.Trailer
+	tr.Total++
+	writeTrailer(t, path, tr)
+	if problems := check(t, path, testPeerID); !hasProblem(problems, "the trailer counts") {
+		t.Fatalf("problems %q, want a count mismatch", problems)
+	}
+
+	// Data after the trailer.
+	if err := ioutil.WriteFile(path, append(data, 'x'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if problems := check(t, path, testPeerID); !hasProblem(problems, "data after the trailer") {
+		t.Fatalf("problems %q, want data after the trailer", problems)
+	}
+}
+
+func writeTrailer(t *testing.T, path string, tr Trailer) {
+	t.Helper()
+	payload, err := json.Marshal(tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := writeFrame(f, frameTrailer, payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrongPeerID(t *testing.T) {
+	path, _ := writeLog(t, testHeader(CompressionNone), [][]ds.Key{testKeys(t, "/blocks", 1), nil}, StateApplied)
+	if problems := check(t, path, "QmOtherPeer"); !hasProblem(problems, "not this one") {
+		t.Fatalf("problems %q, want the wrong repo", problems)
+	}
+}
+
+func TestConvertText(t *testing.T) {
+	blocks := testKeys(t, "/blocks", 5)
+	filestore := testKeys(t, "/filestore", 2)
+	// Older versions appended every key again each time they started.
+	var lines []string
+	for _, ks := range [][]ds.Key{blocks[:3], blocks, filestore} {
+		lines = append(lines, keyStrings(ks)...)
+	}
+	dir := t.TempDir()
+	text := filepath.Join(dir, "cids.txt")
+	if err := ioutil.WriteFile(text, []byte(strings.Join(lines, "\n")+"\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := IsCIDLog(text); err != nil || ok {
+		t.Fatalf("IsCIDLog of a text log = %v, %v", ok, err)
+	}
+	if n, err := CountKeys(text, 0); err != nil || n != uint64(len(lines)) {
+		t.Fatalf("CountKeys of a text log = %d, %v, want %d", n, err, len(lines))
+	}
+	if got := readAll(t, text, 2); !equal(got, lines) {
+		t.Fatalf("read keys %q from a text log, want %q", got, lines)
+	}
+	if _, err := ScanFile(text, false); err != ErrNotCIDLog {
+		t.Fatalf("ScanFile of a text log returned %v, want ErrNotCIDLog", err)
+	}
+	if s, problems, err := Check(text, testPeerID); s != nil || problems != nil || err != nil {
+		t.Fatalf("Check of a text log = %v, %q, %v", s, problems, err)
+	}
+
+	hdr := testHeader(CompressionZstd)
+	path := filepath.Join(dir, "cids.log")
+	size, dups, err := ConvertText(text, path, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dups != 3 {
+		t.Errorf("dropped %d duplicates, want 3", dups)
+	}
+	if got, want := readAll(t, path, 100), keyStrings(blocks, filestore); !equal(got, want) {
+		t.Fatalf("converted keys %q, want %q", got, want)
+	}
+	s, err := ScanFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.Legacy || s.Ended != size || s.Problem != "" || len(s.Prepared) != 0 {
+		t.Fatalf("bad scan of a converted log: %+v", s)
+	}
+	if s.Counts["/blocks"] != uint64(len(blocks)) || s.Counts["/filestore"] != uint64(len(filestore)) {
+		t.Fatalf("counts %v", s.Counts)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind")
+	}
+
+	// The prefixes are then prepared on top of the converted keys.
+	more := testKeys(t, "/blocks", 7)[5:]
+	for i, ks := range [][]ds.Key{more, nil} {
+		w, err := Append(path, hdr, size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range ks {
+			w.Add(i, k)
+		}
+		if size, err = w.EndPrefix(); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+	if err := Seal(path, StateApplied); err != nil {
+		t.Fatal(err)
+	}
+	if problems := check(t, path, testPeerID); len(problems) != 0 {
+		t.Fatalf("problems with a converted log: %q", problems)
+	}
+}
+
+func TestConvertTextBadKey(t *testing.T) {
+	dir := t.TempDir()
+	text := filepath.Join(dir, "cids.txt")
+	lines := keyStrings(testKeys(t, "/other", 1))
+	if err := ioutil.WriteFile(text, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "cids.log")
+	if _, _, err := ConvertText(text, path, testHeader(CompressionNone)); err == nil {
+		t.Fatal("converted a key outside the migrated prefixes")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("log written despite the error")
+	}
+}
@@ -0,0 +1,197 @@
+//go:build !plugins
+// +build !plugins
+
+package mg11
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	humanize "github.com/dustin/go-humanize"
+	badgerds "github.com/ipfs/go-ds-badger"
+	flatfs "github.com/ipfs/go-ds-flatfs"
+	levelds "github.com/ipfs/go-ds-leveldb"
+	"github.com/ipfs/go-ipfs/repo"
+	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
+	ldbopts "github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// loadPlugins registers the datastores of the go-ipfs preloaded plugins with
+// fsrepo, parsed as the plugins parse them. Going through the go-ipfs plugin
+// loader instead would link all of go-ipfs core and its network transports
+// into the migration, so the loader, and the external plugins of the repo at
+// repoPath, are only used by builds with the plugins tag.
+func loadPlugins(repoPath string) error {
+	for name, parse := range map[string]fsrepo.ConfigFromMap{
+		"flatfs":   flatfsConfig,
+		"levelds":  leveldsConfig,
+		"badgerds": badgerdsConfig,
+	} {
+		if err := fsrepo.AddDatastoreConfigHandler(name, parse); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dsPath returns the path of a datastore, relative to the repo at repoPath
+// unless it is absolute.
+func dsPath(repoPath, p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(repoPath, p)
+	}
+	return p
+}
+
+type flatfsDatastoreConfig struct {
+	path      string
+	shardFun  *flatfs.ShardIdV1
+	syncField bool
+}
+
+func flatfsConfig(params map[string]interface{}) (fsrepo.DatastoreConfig, error) {
+	var c flatfsDatastoreConfig
+	var ok bool
+	var err error
+
+	c.path, ok = params["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("'path' field is missing or not string")
+	}
+
+	sshardFun, ok := params["shardFunc"].(string)
+	if !ok {
+		return nil, fmt.Errorf("'shardFunc' field is missing or not a string")
+	}
+	c.shardFun, err = flatfs.ParseShardFunc(sshardFun)
+	if err != nil {
+		return nil, err
+	}
+
+	c.syncField, ok = params["sync"].(bool)
+	if !ok {
+		return nil, fmt.Errorf("'sync' field is missing or not boolean")
+	}
+	return &c, nil
+}
+
+func (c *flatfsDatastoreConfig) DiskSpec() fsrepo.DiskSpec {
+	return map[string]interface{}{
+		"type":      "flatfs",
+		"path":      c.path,
+		"shardFunc": c.shardFun.String(),
+	}
+}
+
+func (c *flatfsDatastoreConfig) Create(path string) (repo.Datastore, error) {
+	return flatfs.CreateOrOpen(dsPath(path, c.path), c.shardFun, c.syncField)
+}
+
+type leveldsDatastoreConfig struct {
+	path        string
+	compression ldbopts.Compression
+}
+
+func leveldsConfig(params map[string]interface{}) (fsrepo.DatastoreConfig, error) {
+	var c leveldsDatastoreConfig
+	var ok bool
+
+	c.path, ok = params["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("'path' field is missing or not string")
+	}
+
+	switch cm := params["compression"].(string); cm {
+	case "none":
+		c.compression = ldbopts.NoCompression
+	case "snappy":
+		c.compression = ldbopts.SnappyCompression
+	case "":
+		c.compression = ldbopts.DefaultCompression
+	default:
+		return nil, fmt.Errorf("unrecognized value for compression: %s", cm)
+	}
+	return &c, nil
+}
+
+func (c *leveldsDatastoreConfig) DiskSpec() fsrepo.DiskSpec {
+	return map[string]interface{}{
+		"type": "levelds",
+		"path": c.path,
+	}
+}
+
+func (c *leveldsDatastoreConfig) Create(path string) (repo.Datastore, error) {
+	return levelds.NewDatastore(dsPath(path, c.path), &levelds.Options{
+		Compression: c.compression,
+	})
+}
+
+type badgerdsDatastoreConfig struct {
+	path       string
+	syncWrites bool
+	truncate   bool
+
+	vlogFileSize int64
+}
+
+func badgerdsConfig(params map[string]interface{}) (fsrepo.DatastoreConfig, error) {
+	var c badgerdsDatastoreConfig
+	var ok bool
+
+	c.path, ok = params["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("'path' field is missing or not string")
+	}
+
+	c.syncWrites = false
+	if sw, ok := params["syncWrites"]; ok {
+		if c.syncWrites, ok = sw.(bool); !ok {
+			return nil, fmt.Errorf("'syncWrites' field was not a boolean")
+		}
+	}
+
+	c.truncate = true
+	if truncate, ok := params["truncate"]; ok {
+		if c.truncate, ok = truncate.(bool); !ok {
+			return nil, fmt.Errorf("'truncate' field was not a boolean")
+		}
+	}
+
+	// default to 1GiB
+	c.vlogFileSize = badgerds.DefaultOptions.ValueLogFileSize
+	if vls, ok := params["vlogFileSize"]; ok {
+		vlogSize, ok := vls.(string)
+		if !ok {
+			return nil, fmt.Errorf("'vlogFileSize' field was not a string")
+		}
+		s, err := humanize.ParseBytes(vlogSize)
+		if err != nil {
+			return nil, err
+		}
+		c.vlogFileSize = int64(s)
+	}
+	return &c, nil
+}
+
+func (c *badgerdsDatastoreConfig) DiskSpec() fsrepo.DiskSpec {
+	return map[string]interface{}{
+		"type": "badgerds",
+		"path": c.path,
+	}
+}
+
+func (c *badgerdsDatastoreConfig) Create(path string) (repo.Datastore, error) {
+	p := dsPath(path, c.path)
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return nil, err
+	}
+
+	defopts := badgerds.DefaultOptions
+	defopts.SyncWrites = c.syncWrites
+	defopts.Truncate = c.truncate
+	defopts.ValueLogFileSize = c.vlogFileSize
+
+	return badgerds.NewDatastore(p, &defopts)
+}
@@ -0,0 +1,192 @@
+package mg11
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ipfs/fs-repo-migrations/fs-repo-11-to-12/migration/cidlog"
+)
+
+// Environment variables that set the defaults of the flags, for when the
+// migration is run by a tool that cannot pass it flags.
+const (
+	workersEnvVar        = "IPFS_FS_MIGRATION_11_TO_12_NWORKERS"
+	syncSizeEnvVar       = "IPFS_FS_MIGRATION_11_TO_12_SYNC_SIZE_BYTES"
+	flatfsFastPathEnvVar = "IPFS_FS_MIGRATION_11_TO_12_ENABLE_FLATFS_FASTPATH"
+	ioRateEnvVar         = "IPFS_FS_MIGRATION_11_TO_12_IO_RATE"
+	compressionEnvVar    = "IPFS_FS_MIGRATION_11_TO_12_LOG_COMPRESSION"
+	verifyEnvVar         = "IPFS_FS_MIGRATION_11_TO_12_VERIFY_REACHABLE"
+)
+
+// RegisterFlags defines the flags that tune how keys are swapped, -workers,
+// -sync-size, -flatfs-fast-path and -io-rate, -log-compression and
+// -verify-reachable. The
+// IPFS_FS_MIGRATION_11_TO_12_* environment variables set their defaults; a
+// bad value in one of those is returned by Apply and Revert.
+func (m *Migration) RegisterFlags(fs *flag.FlagSet) {
+	workers := &autoValue{set: setWorkers, get: func() string { return strconv.Itoa(NWorkers) }, auto: &autoWorkers}
+	syncSize := &autoValue{set: setSyncSize, get: func() string { return strconv.FormatUint(SyncSize, 10) }, auto: &autoSyncSize}
+	fastPath := boolValue{&EnableFlatFSFastPath}
+	ioRate := ioRateValue{}
+	compression := compressionValue{}
+	verify := boolValue{&VerifyReachable}
+
+	for _, e := range []struct {
+		env string
+		v   flag.Value
+	}{
+		{workersEnvVar, workers},
+		{syncSizeEnvVar, syncSize},
+		{flatfsFastPathEnvVar, fastPath},
+		{ioRateEnvVar, ioRate},
+		{compressionEnvVar, compression},
+		{verifyEnvVar, verify},
+	} {
+		if s, ok := os.LookupEnv(e.env); ok {
+			if err := e.v.Set(s); err != nil && m.flagErr == nil {
+				m.flagErr = fmt.Errorf("invalid %s: %s", e.env, err)
+			}
+		}
+	}
+
+	fs.Var(workers, "workers", "`number` of workers swapping keys, or \"auto\" to pick it from the CPU count and fsync latency")
+	fs.Var(syncSize, "sync-size", "`bytes` each worker writes between syncs, such as \"100MiB\", or \"auto\" to pick it from the fsync latency")
+	fs.Var(fastPath, "flatfs-fast-path", "rename the files of a flatfs datastore instead of copying the blocks")
+	fs.Var(ioRate, "io-rate", "`bytes` per second to read and write at most while swapping keys, such as \"20MiB\" (default no limit)")
+	fs.Var(compression, "log-compression", "compression of the keys in a new backup file, \"none\" or \"zstd\"")
+	fs.Var(verify, "verify-reachable", "check that the blocks of the pins and the MFS tree are reachable once the keys are swapped")
+}
+
+// autoValue is a flag that takes a value or "auto".
+type autoValue struct {
+	set  func(string) error
+	get  func() string
+	auto *bool
+}
+
+func (v *autoValue) String() string {
+	if v.get == nil {
+		return ""
+	}
+	if *v.auto {
+		return "auto"
+	}
+	return v.get()
+}
+
+func (v *autoValue) Set(s string) error {
+	if s == "auto" {
+		*v.auto = true
+		return nil
+	}
+	if err := v.set(s); err != nil {
+		return err
+	}
+	*v.auto = false
+	return nil
+}
+
+func setWorkers(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return fmt.Errorf("%q is not a number of workers of at least 1", s)
+	}
+	NWorkers = n
+	return nil
+}
+
+func setSyncSize(s string) error {
+	n, err := parseBytes(s)
+	if err != nil {
+		return err
+	}
+	if n < 1 {
+		return fmt.Errorf("sync size must be at least 1 byte")
+	}
+	SyncSize = n
+	return nil
+}
+
+// boolValue is a bool flag whose value is checked like that of flag.Bool.
+type boolValue struct{ b *bool }
+
+func (v boolValue) String() string {
+	if v.b == nil {
+		return ""
+	}
+	return strconv.FormatBool(*v.b)
+}
+
+func (v boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a boolean", s)
+	}
+	*v.b = b
+	return nil
+}
+
+func (v boolValue) IsBoolFlag() bool { return true }
+
+// ioRateValue sets IORate.
+type ioRateValue struct{}
+
+func (ioRateValue) String() string {
+	if IORate == 0 {
+		return ""
+	}
+	return strconv.FormatUint(IORate, 10)
+}
+
+func (ioRateValue) Set(s string) error {
+	n, err := parseBytes(s)
+	if err != nil {
+		return err
+	}
+	IORate = n
+	return nil
+}
+
+// compressionValue sets LogCompression.
+type compressionValue struct{}
+
+func (compressionValue) String() string {
+	return LogCompression
+}
+
+func (compressionValue) Set(s string) error {
+	if s != cidlog.CompressionNone && s != cidlog.CompressionZstd {
+		return fmt.Errorf("%q is not %q or %q", s, cidlog.CompressionNone, cidlog.CompressionZstd)
+	}
+	LogCompression = s
+	return nil
+}
+
+// byteUnits are the suffixes parseBytes accepts, longest first.
+var byteUnits = []struct {
+	suffix string
+	n      uint64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30},
+	{"kB", 1e3}, {"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9},
+	{"B", 1},
+}
+
+// parseBytes parses a number of bytes such as "4096", "100MiB" or "1GB".
+func parseBytes(s string) (uint64, error) {
+	num, unit := strings.TrimSpace(s), uint64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(num, u.suffix) {
+			num, unit = strings.TrimSpace(strings.TrimSuffix(num, u.suffix)), u.n
+			break
+		}
+	}
+	n, err := strconv.ParseUint(num, 10, 64)
+	if err != nil || n > ^uint64(0)/unit {
+		return 0, fmt.Errorf("%q is not a number of bytes, such as 4096 or 100MiB", s)
+	}
+	return n * unit, nil
+}
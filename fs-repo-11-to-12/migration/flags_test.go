@@ -0,0 +1,220 @@
+package mg11
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/fs-repo-migrations/fs-repo-11-to-12/migration/cidlog"
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+)
+
+func TestParseBytes(t *testing.T) {
+	cases := []struct {
+		s    string
+		want uint64
+	}{
+		{"0", 0},
+		{"4096", 4096},
+		{" 4096 ", 4096},
+		{"4096B", 4096},
+		{"100MiB", 100 << 20},
+		{"100 MiB", 100 << 20},
+		{"8KiB", 8 << 10},
+		{"1GiB", 1 << 30},
+		{"8kB", 8000},
+		{"8KB", 8000},
+		{"20MB", 20e6},
+		{"1GB", 1e9},
+		{"17179869183GiB", (1<<34 - 1) << 30},
+	}
+	for _, c := range cases {
+		got, err := parseBytes(c.s)
+		if err != nil {
+			t.Errorf("%q: %s", c.s, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%q parsed to %d, expected %d", c.s, got, c.want)
+		}
+	}
+
+	for _, s := range []string{"", "MiB", "-1", "1.5MiB", "100MiBs", "100 mib", "1TiB", "0x10", "17179869184GiB"} {
+		if n, err := parseBytes(s); err == nil {
+			t.Errorf("expected an error parsing %q, got %d", s, n)
+		}
+	}
+}
+
+// saveSettings restores the settings the flags set when the test ends.
+func saveSettings(t *testing.T) {
+	workers, syncSize, auto := NWorkers, SyncSize, [2]bool{autoWorkers, autoSyncSize}
+	fastPath, ioRate, compression, verify := EnableFlatFSFastPath, IORate, LogCompression, VerifyReachable
+	t.Cleanup(func() {
+		NWorkers, SyncSize, autoWorkers, autoSyncSize = workers, syncSize, auto[0], auto[1]
+		EnableFlatFSFastPath, IORate, LogCompression, VerifyReachable = fastPath, ioRate, compression, verify
+	})
+}
+
+// setEnv sets the environment variables in env for the length of the test.
+func setEnv(t *testing.T, env map[string]string) {
+	for k, v := range env {
+		k := k
+		old, ok := os.LookupEnv(k)
+		os.Setenv(k, v)
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(k, old)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestRegisterFlags(t *testing.T) {
+	saveSettings(t)
+	setEnv(t, map[string]string{
+		workersEnvVar:        "3",
+		syncSizeEnvVar:       "auto",
+		flatfsFastPathEnvVar: "false",
+		ioRateEnvVar:         "20MiB",
+		compressionEnvVar:    cidlog.CompressionNone,
+		verifyEnvVar:         "0",
+	})
+
+	m := Migration{}
+	fs := flag.NewFlagSet("migration", flag.ContinueOnError)
+	m.RegisterFlags(fs)
+	if m.flagErr != nil {
+		t.Fatal(m.flagErr)
+	}
+	if NWorkers != 3 || autoWorkers || !autoSyncSize || EnableFlatFSFastPath || IORate != 20<<20 || LogCompression != cidlog.CompressionNone || VerifyReachable {
+		t.Fatalf("environment set workers %d (auto %t), sync size auto %t, fast path %t, io rate %d, compression %s, verify %t",
+			NWorkers, autoWorkers, autoSyncSize, EnableFlatFSFastPath, IORate, LogCompression, VerifyReachable)
+	}
+	if got := fs.Lookup("sync-size").Value.String(); got != "auto" {
+		t.Errorf("-sync-size shows %q", got)
+	}
+
+	// Flags override the environment.
+	err := fs.Parse([]string{"-workers", "auto", "-sync-size", "1MiB", "-flatfs-fast-path", "-io-rate", "1KB", "-log-compression", cidlog.CompressionZstd, "-verify-reachable=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !autoWorkers || autoSyncSize || SyncSize != 1<<20 || !EnableFlatFSFastPath || IORate != 1000 || LogCompression != cidlog.CompressionZstd || !VerifyReachable {
+		t.Fatalf("flags set workers auto %t, sync size %d (auto %t), fast path %t, io rate %d, compression %s, verify %t",
+			autoWorkers, SyncSize, autoSyncSize, EnableFlatFSFastPath, IORate, LogCompression, VerifyReachable)
+	}
+}
+
+func TestRegisterFlagsBadEnv(t *testing.T) {
+	cases := []struct {
+		env, value string
+	}{
+		{workersEnvVar, "0"},
+		{workersEnvVar, "many"},
+		{syncSizeEnvVar, "0"},
+		{syncSizeEnvVar, "100 megs"},
+		{flatfsFastPathEnvVar, "yes"},
+		{ioRateEnvVar, "fast"},
+		{compressionEnvVar, "gzip"},
+		{verifyEnvVar, "on"},
+	}
+	for _, c := range cases {
+		t.Run(c.env+"="+c.value, func(t *testing.T) {
+			saveSettings(t)
+			setEnv(t, map[string]string{c.env: c.value})
+			m := Migration{}
+			m.RegisterFlags(flag.NewFlagSet("migration", flag.ContinueOnError))
+			opts := migrate.Options{Flags: migrate.Flags{Path: workingRepo}}
+			if m.flagErr == nil || !strings.Contains(m.flagErr.Error(), c.env) {
+				t.Fatalf("got flag error %v, expected one naming %s", m.flagErr, c.env)
+			}
+			if err := m.Apply(opts); err != m.flagErr {
+				t.Fatalf("Apply returned %v, expected %v", err, m.flagErr)
+			}
+			if err := m.Revert(opts); err != m.flagErr {
+				t.Fatalf("Revert returned %v, expected %v", err, m.flagErr)
+			}
+		})
+	}
+}
+
+func TestBadFlags(t *testing.T) {
+	for _, args := range [][]string{
+		{"-workers", "0"},
+		{"-workers", "-2"},
+		{"-sync-size", "0"},
+		{"-sync-size", "1.5GiB"},
+		{"-io-rate", "-1"},
+		{"-log-compression", "deflate"},
+		{"-flatfs-fast-path=maybe"},
+		{"-verify-reachable=2"},
+	} {
+		saveSettings(t)
+		fs := flag.NewFlagSet("migration", flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		(&Migration{}).RegisterFlags(fs)
+		if err := fs.Parse(args); err == nil {
+			t.Errorf("expected an error parsing %q", args)
+		}
+	}
+}
+
+// TestPreflightAuto checks that Preflight does not measure the fsync latency
+// in auto mode, as that writes to the repo, which is not locked yet, and
+// that it assumes the largest settings auto mode can pick instead.
+func TestPreflightAuto(t *testing.T) {
+	saveSettings(t)
+	copyRepo(t)
+	EnableFlatFSFastPath = false
+	autoWorkers, autoSyncSize = true, true
+	NWorkers, SyncSize = 1, 1
+
+	// tune writes its probe file at the root of the repo.
+	dir := workingRepo
+	before, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	need, err := (&Migration{}).Preflight(workingRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NWorkers != 1 || SyncSize != 1 {
+		t.Fatalf("Preflight tuned the settings to %d workers and a sync size of %d", NWorkers, SyncSize)
+	}
+	after, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(before) {
+		t.Fatalf("Preflight left %d files in %s, there were %d", len(after), dir, len(before))
+	}
+
+	autoWorkers, autoSyncSize = false, false
+	fixed, err := (&Migration{}).Preflight(workingRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if need.Bytes <= fixed.Bytes {
+		t.Fatalf("needs %d bytes in auto mode, and %d with a sync size of 1 byte", need.Bytes, fixed.Bytes)
+	}
+}
+
+func TestMaxSettings(t *testing.T) {
+	saveSettings(t)
+	NWorkers, SyncSize = 3, 5
+	autoWorkers, autoSyncSize = false, false
+	if workers, syncSize := maxSettings(); workers != 3 || syncSize != 5 {
+		t.Fatalf("got %d workers and a sync size of %d without auto mode", workers, syncSize)
+	}
+	autoWorkers, autoSyncSize = true, true
+	workers, syncSize := maxSettings()
+	if workers < 2 || workers > maxAutoWorkers || syncSize != maxAutoSyncSize {
+		t.Fatalf("got %d workers and a sync size of %d in auto mode", workers, syncSize)
+	}
+}
@@ -4,14 +4,16 @@
 package mg11
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	log "github.com/ipfs/fs-repo-migrations/tools/stump"
 
+	"github.com/ipfs/fs-repo-migrations/fs-repo-11-to-12/migration/cidlog"
+	dsspec "github.com/ipfs/fs-repo-migrations/tools/dsspec"
 	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
 	mfsr "github.com/ipfs/fs-repo-migrations/tools/mfsr"
 	cid "github.com/ipfs/go-cid"
@@ -21,8 +23,6 @@ import (
 	gc "github.com/ipfs/go-ipfs/gc"
 )
 
-const backupFile = "11-to-12-cids.txt"
-
 // mfs root key path in the datastore
 var mfsRootKey = ds.NewKey("/local/filesroot")
 
@@ -42,6 +42,14 @@ var migrationPrefixes = []ds.Key{
 // Migration implements the migration described above.
 type Migration struct {
 	dstore ds.Batching
+	// blocks is the backend serving /blocks on its own, if there is one.
+	blocks *dsspec.Backend
+	// flagErr is a bad value of the environment variables read by
+	// RegisterFlags.
+	flagErr error
+	// converted holds the keys converted from a text backup file, which
+	// prepareBackup skips.
+	converted map[ds.Key]struct{}
 }
 
 // Versions returns the current version string for this migration.
@@ -54,16 +62,127 @@ func (m *Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m *Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Re-keys the blocks in the datastore by multihash instead of CID, and updates the pins and the MFS root to match.",
+		Areas:   []string{migrate.AreaBlocks, migrate.AreaPins},
+	}
+}
+
+// Estimate counts the blocks in the blockstore, which are all looked at. Only
+// those stored under a CIDv1 are rewritten.
+func (m *Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	b, ok, err := blocksBackend(repoPath)
+	if err != nil {
+		return migrate.Cost{}, err
+	}
+	if !ok || b.Type != flatfsType {
+		// Blocks cannot be counted without opening the datastore.
+		bytes, err := datastoreSize(repoPath)
+		return migrate.Cost{Bytes: bytes, Note: "size of the datastore; the blocks are not in flatfs and were not counted"}, err
+	}
+	n, bytes, err := migrate.DirUsage(b.Path, ".data")
+	return migrate.Cost{Items: n, Unit: "blocks", Bytes: bytes}, err
+}
+
+// datastoreSize returns the size of the backends of the datastore of the repo
+// at repoPath that are stored on disk.
+func datastoreSize(repoPath string) (int64, error) {
+	backends, err := dsspec.Load(repoPath)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	seen := make(map[string]bool)
+	for _, b := range backends {
+		if b.Path == "" || seen[b.Path] {
+			continue
+		}
+		seen[b.Path] = true
+		_, bytes, err := migrate.DirUsage(b.Path, "")
+		if err != nil {
+			return 0, err
+		}
+		total += bytes
+	}
+	return total, nil
+}
+
+// Preflight estimates the space needed by the CIDv1 blocks, which are written
+// again under their multihash before the old copies are deleted, and by the
+// log of their CIDs. In flatfs the fast path renames them, and otherwise at
+// most SyncSize bytes per worker are written before they are deleted. Other
+// datastores only free the space of deleted blocks when they compact, so all
+// of it is needed.
+func (m *Migration) Preflight(repoPath string) (migrate.Space, error) {
+	b, ok, err := blocksBackend(repoPath)
+	if err != nil {
+		return migrate.Space{}, err
+	}
+	if !ok || b.Type != flatfsType {
+		bytes, err := datastoreSize(repoPath)
+		return migrate.Space{
+			Path:  repoPath,
+			Bytes: bytes,
+			Files: 1,
+			Note:  "the blocks are not in flatfs and were not counted, so the whole datastore is assumed to be CIDv1",
+		}, err
+	}
+
+	var v1, v1Bytes, logBytes int64
+	err = filepath.Walk(b.Path, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := fi.Name()
+		// Keys are the base32 of the CID, and the 0x01 that CIDv1 start
+		// with is an "A".
+		if fi.Mode().IsRegular() && strings.HasSuffix(name, ".data") && strings.HasPrefix(name, "A") {
+			v1++
+			v1Bytes += fi.Size()
+			// The CID as a string is one character longer, and ends
+			// with a newline in the log.
+			logBytes += int64(len(name)-len(".data")) + 2
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return migrate.Space{}, err
+	}
+
+	need := migrate.Space{Path: repoPath, Bytes: logBytes, Files: 1}
+	if v1Bytes == 0 || EnableFlatFSFastPath {
+		return need, nil
+	}
+	// The repo is not locked yet, so the settings of auto mode are only
+	// measured by Apply, and the largest ones are assumed here.
+	workers, syncSize := maxSettings()
+	batch := int64(syncSize) * int64(workers)
+	if batch > v1Bytes {
+		batch = v1Bytes
+	}
+	need.Bytes += batch
+	need.Files += v1 * batch / v1Bytes
+	return need, nil
+}
+
 // Apply runs the migration and writes a log file that can be used by Revert.
 // Steps:
 // - Open a raw datastore using go-ipfs settings
 // - Simulate the migration and save a backup log
 // - Run the migration by storing all CIDv1 addressed logs as raw-multihash
 //   addressed.
+// - Unless VerifyReachable is unset, verify that the blocks of pins and MFS
+//   are stored under their multihash.
 func (m *Migration) Apply(opts migrate.Options) error {
 	log.Verbose = opts.Verbose
 	log.Log("applying %s repo migration", m.Versions())
 
+	if m.flagErr != nil {
+		return m.flagErr
+	}
+
 	lk, err := m.lock(opts)
 	if err != nil {
 		return err
@@ -81,60 +200,70 @@ func (m *Migration) Apply(opts migrate.Options) error {
 	if err != nil {
 		return err
 	}
-	defer m.dstore.Close()
+	defer func() {
+		// reclaim closes it to compact leveldb.
+		if m.dstore != nil {
+			m.dstore.Close()
+		}
+	}()
+
+	if opts.DryRun {
+		return m.dryRun()
+	}
+	tune(opts.Path)
 
-	log.VLog("  - starting CIDv1 to raw multihash block migration")
+	log.Phase("  - starting CIDv1 to raw multihash block migration")
 
-	f, err := createBackupFile(opts.Path, backupFile)
+	cp, err := loadCheckpoint(opts.Path)
+	if err != nil {
+		log.Error(err)
+		return err
+	}
+	backupPath := filepath.Join(opts.Path, backupFile)
+	hdr, err := startLog(opts.Path, cp)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
-	buf := bufio.NewWriter(f)
-
-	swapCh := make(chan Swap, 1000)
-	writingDone := make(chan struct{})
-
-	// Write the old CIDv1-key of every swapped item to the buffer.
-	go func() {
-		for sw := range swapCh {
-			// Only write the Old string (a CID). We can derive
-			// the multihash from it.
-			fmt.Fprint(buf, sw.Old.String(), "\n")
-		}
-		close(writingDone)
-	}()
 
 	// DRY RUN: A swapper for each migration prefix and write everything
 	// to the file.
 	//
 	// Reasoning: We do not want a broken or unwritten file if the
-	// migration blows up. If it does blow up half-way through, and we
-	// need to run it again, we will only append to this file. Having
-	// potential duplicate entries in the backup file will not break
-	// reverts.
-	for _, prefix := range migrationPrefixes {
-		log.VLog("  - Adding keys in prefix %s to backup file", prefix)
-		cidSwapper := CidSwapper{Prefix: prefix, Store: m.dstore, SwapCh: swapCh}
-		total, err := cidSwapper.Prepare() // DRY RUN
-		if err != nil {
-			close(swapCh)
+	// migration blows up. If it does blow up half-way through, the
+	// checkpoint says which prefixes are complete in the file, and what
+	// was appended for the others is dropped when running again.
+	for i, prefix := range migrationPrefixes {
+		if cp.prepared(prefix.String()) {
+			log.VLog("  - keys in prefix %s are already in backup file", prefix)
+			continue
+		}
+		if err := m.prepareBackup(backupPath, hdr, cp, i, prefix); err != nil {
+			log.Error(err)
+			return err
+		}
+	}
+	if !cp.Sealed {
+		if err := cidlog.Seal(backupPath, cidlog.StatePrepared); err != nil {
+			log.Error(err)
+			return err
+		}
+		cp.Sealed = true
+		if err := cp.save(); err != nil {
 			log.Error(err)
 			return err
 		}
-		log.Log("%d CIDv1 keys added to backup file for %s", total, prefix)
 	}
-	close(swapCh)
-	// Wait for our writing to finish before doing the flushing.
-	<-writingDone
-	buf.Flush()
-	f.Close()
 
-	err = m.scanAndSwap(filepath.Join(opts.Path, backupFile), false) // revert=false
+	err = m.scanAndSwap(backupPath, false, cp) // revert=false
 	if err != nil {
 		log.Error(err)
 		return err
 	}
+	if err := cidlog.Seal(backupPath, cidlog.StateApplied); err != nil {
+		log.Error(err)
+		return err
+	}
 
 	// Wrap up, we are now in repo-version 12.
 	if err := repo.WriteVersion("12"); err != nil {
@@ -143,6 +272,211 @@ func (m *Migration) Apply(opts migrate.Options) error {
 	}
 	log.Log("updated version file")
 
+	if err := cp.remove(); err != nil {
+		log.Error("could not remove %s, but migration worked: %s", checkpointFile, err)
+	}
+
+	// Blocks that are unreachable now were most likely missing before the
+	// migration, so the repo is left at version 12, but the migration
+	// fails so that a chain of migrations stops for them to be looked at.
+	if VerifyReachable {
+		if err = m.verifyReachable(); err != nil {
+			err = fmt.Errorf("migration applied, but %s; run 'verify-reachable' to check again", err)
+			log.Error(err)
+		}
+	}
+	m.reclaim()
+	return err
+}
+
+// startLog gets the backup file of the repo at repoPath ready for Prepare,
+// and returns its header. It creates the file, or checks that the one left
+// by an interrupted run belongs to the repo. One in the text format of older
+// versions is converted, and one without a checkpoint is continued from what
+// it records.
+func startLog(repoPath string, cp *checkpoint) (cidlog.Header, error) {
+	backupPath := filepath.Join(repoPath, backupFile)
+	log.VLog("  - backup file will be written to %s", backupPath)
+	hdr, err := newLogHeader(repoPath)
+	if err != nil {
+		return hdr, err
+	}
+
+	_, err = os.Stat(backupPath)
+	switch {
+	case os.IsNotExist(err):
+		size, err := cidlog.Create(backupPath, hdr)
+		if err != nil {
+			return hdr, err
+		}
+		*cp = checkpoint{path: cp.path, LogSize: size}
+		return hdr, cp.save()
+
+	case err != nil:
+		return hdr, err
+
+	}
+
+	isLog, err := cidlog.IsCIDLog(backupPath)
+	if err != nil {
+		return hdr, err
+	}
+	if !isLog {
+		// An earlier run of an older version was interrupted. Its
+		// keys may already be swapped, so they are kept for reverts.
+		log.Warn("backup file %s of an interrupted migration is in an older format, converting it", backupFile)
+		size, dups, err := cidlog.ConvertText(backupPath, backupPath, hdr)
+		if err != nil {
+			return hdr, err
+		}
+		log.Count("backup_duplicates", dups, "removed %d duplicate keys from %s", dups, backupFile)
+		*cp = checkpoint{path: cp.path, LogSize: size, Converted: size}
+		return hdr, cp.save()
+	}
+
+	l, err := cidlog.Open(backupPath)
+	if err != nil {
+		return hdr, err
+	}
+	l.Close()
+	if l.Header().PeerID != hdr.PeerID {
+		return hdr, fmt.Errorf("backup file %s was written for repo %s, not this one, %s", backupFile, l.Header().PeerID, hdr.PeerID)
+	}
+	if cp.LogSize == 0 {
+		s, err := cidlog.ScanFile(backupPath, false)
+		if err != nil {
+			return hdr, err
+		}
+		log.Warn("%s is missing, continuing from what %s records", checkpointFile, backupFile)
+		*cp = checkpoint{path: cp.path, Prepared: s.Prepared, LogSize: s.Ended}
+		if s.Legacy {
+			cp.Converted = s.Ended
+		}
+		if err := cp.save(); err != nil {
+			return hdr, err
+		}
+	}
+	if cp.started() {
+		log.Log("resuming interrupted migration: %d of %d prefixes in the backup file, %d of %d bytes of it swapped",
+			len(cp.Prepared), len(migrationPrefixes), cp.Swapped, cp.LogSize)
+	}
+	return l.Header(), nil
+}
+
+// prepareBackup appends the CIDv1 keys in prefix, the one at index in the
+// header, to the backup file, after dropping whatever an interrupted run
+// appended past the last checkpoint, and records in the checkpoint that
+// prefix is done.
+func (m *Migration) prepareBackup(backupPath string, hdr cidlog.Header, cp *checkpoint, index int, prefix ds.Key) error {
+	log.VLog("  - Adding keys in prefix %s to backup file", prefix)
+	w, err := cidlog.Append(backupPath, hdr, cp.LogSize)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if cp.Converted != 0 && m.converted == nil {
+		if m.converted, err = loggedKeys(backupPath, cp.Converted); err != nil {
+			return err
+		}
+	}
+
+	swapCh := make(chan Swap, 1000)
+	writingDone := make(chan struct{})
+
+	// Write the old CIDv1-key of every swapped item to the log, unless
+	// it was converted from a text backup file.
+	var werr error
+	var skipped uint64
+	go func() {
+		for sw := range swapCh {
+			if _, ok := m.converted[sw.Old]; ok {
+				skipped++
+				continue
+			}
+			// Only write the Old key (a CID). We can derive
+			// the multihash from it.
+			if werr == nil {
+				werr = w.Add(index, sw.Old)
+			}
+		}
+		close(writingDone)
+	}()
+
+	cidSwapper := CidSwapper{Prefix: prefix, Store: m.dstore, SwapCh: swapCh}
+	total, err := cidSwapper.Prepare() // DRY RUN
+	close(swapCh)
+	// Wait for our writing to finish before ending the prefix.
+	<-writingDone
+	if err != nil {
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+	size, err := w.EndPrefix()
+	if err != nil {
+		return err
+	}
+	total -= skipped
+	log.Count("backup_keys", total, "%d CIDv1 keys added to backup file for %s", total, prefix)
+
+	cp.LogSize = size
+	cp.Prepared = append(cp.Prepared, prefix.String())
+	return cp.save()
+}
+
+// loggedKeys returns the keys in the backup file at backupPath, up to at
+// least offset off.
+func loggedKeys(backupPath string, off int64) (map[ds.Key]struct{}, error) {
+	l, err := cidlog.Open(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Close()
+	keys := make(map[ds.Key]struct{})
+	for l.Offset() < off {
+		entries, err := l.Next(swapChunk)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, e := range entries {
+			keys[e.Key] = struct{}{}
+		}
+	}
+	return keys, nil
+}
+
+// dryRun lists every CIDv1 key that Apply would swap for its raw multihash,
+// using CidSwapper.Prepare, without writing the backup file or touching the
+// datastore.
+func (m *Migration) dryRun() error {
+	var total uint64
+	for _, prefix := range migrationPrefixes {
+		swapCh := make(chan Swap, 1000)
+		printingDone := make(chan struct{})
+		go func() {
+			for sw := range swapCh {
+				log.Log("  %s -> %s", sw.Old, sw.New)
+			}
+			close(printingDone)
+		}()
+
+		log.Log("dry run: keys in %s that would be swapped", prefix)
+		cidSwapper := CidSwapper{Prefix: prefix, Store: m.dstore, SwapCh: swapCh}
+		n, err := cidSwapper.Prepare()
+		close(swapCh)
+		<-printingDone
+		if err != nil {
+			log.Error(err)
+			return err
+		}
+		log.Count("keys", n, "dry run: %d CIDv1 keys in %s would be swapped", n, prefix)
+		total += n
+	}
+	log.Count("keys_total", total, "dry run: %d CIDv1 keys would be swapped in total", total)
 	return nil
 }
 
@@ -157,6 +491,10 @@ func (m *Migration) Revert(opts migrate.Options) error {
 	log.Verbose = opts.Verbose
 	log.Log("reverting %s repo migration", m.Versions())
 
+	if m.flagErr != nil {
+		return m.flagErr
+	}
+
 	lk, err := m.lock(opts)
 	if err != nil {
 		return err
@@ -170,16 +508,22 @@ func (m *Migration) Revert(opts migrate.Options) error {
 		return err
 	}
 
-	log.VLog("  - starting raw multihash to CIDv1 block migration")
+	log.Phase("  - starting raw multihash to CIDv1 block migration")
+	tune(opts.Path)
 	err = m.open(opts)
 	if err != nil {
 		return err
 	}
 	defer m.dstore.Close()
 
-	// Open revert path for reading
+	// Open revert path for reading. It is in the text format of an older
+	// version if that version applied the migration.
 	backupPath := filepath.Join(opts.Path, backupFile)
-	err = m.scanAndSwap(backupPath, true) // revert = true
+	if err := verifyBeforeRevert(opts, backupPath); err != nil {
+		log.Error(err)
+		return err
+	}
+	err = m.scanAndSwap(backupPath, true, nil) // revert = true
 	if err != nil {
 		log.Error(err)
 		return err
@@ -193,6 +537,13 @@ func (m *Migration) Revert(opts migrate.Options) error {
 
 	log.Log("reverted version file to version 11")
 
+	// A checkpoint left by an Apply that finished would make the next
+	// Apply skip everything.
+	if err := os.Remove(filepath.Join(opts.Path, checkpointFile)); err != nil && !os.IsNotExist(err) {
+		log.Error("could not remove %s, but migration worked: %s", checkpointFile, err)
+		return err
+	}
+
 	// Move the backup file out of the way.
 	err = os.Rename(backupPath, backupPath+".reverted")
 	if err != nil {
@@ -202,96 +553,141 @@ func (m *Migration) Revert(opts migrate.Options) error {
 	return nil
 }
 
+// verifyBeforeRevert checks the backup file at backupPath before Revert
+// trusts it with the keys to swap back. Problems are errors, unless
+// opts.Force is set.
+func verifyBeforeRevert(opts migrate.Options, backupPath string) error {
+	hdr, err := newLogHeader(opts.Path)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(backupPath)
+	s, problems, err := cidlog.Check(backupPath, hdr.PeerID)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		log.Warn("backup file %s is in the text format of older versions, which cannot be verified", name)
+		return nil
+	}
+	if len(problems) == 0 {
+		log.VLog("  - verified backup file: %d keys", s.Trailer.Total)
+		return nil
+	}
+	for _, p := range problems {
+		log.Warn("backup file %s: %s", name, p)
+	}
+	if opts.Force {
+		log.Warn("reverting with a backup file that failed verification, as forced")
+		return nil
+	}
+	return fmt.Errorf("backup file %s failed verification; run 'verify-log' for details, or use -f to revert anyway", name)
+}
+
+// swapChunk is the number of keys from the backup file that are swapped
+// between checkpoints.
+const swapChunk = 100000
+
 // Receives a backup file which contains all the things that need to be
-// migrated and reads every line, performing swaps in the needed direction.
-func (m *Migration) scanAndSwap(backupPath string, revert bool) error {
-	f, err := getBackupFile(backupPath)
+// migrated and reads every key, performing swaps in the needed direction.
+// The keys are swapped in chunks, and when cp is not nil, it records after
+// each chunk how far in the file the swaps are done, and they resume from
+// there.
+func (m *Migration) scanAndSwap(backupPath string, revert bool, cp *checkpoint) error {
+	log.VLog("  - backup file will be read from %s", backupPath)
+	l, err := cidlog.Open(backupPath)
 	if err != nil {
 		log.Error(err)
 		return err
 	}
-	defer f.Close()
+	defer l.Close()
 
-	swapCh := make(chan Swap, 1000)
-	scanner := bufio.NewScanner(f)
-	var scannerErr error
-
-	// This will send swap objects to the swapping channel as they
-	// are read from the backup file on disk. It will also send MFS and
-	// pinset pins for reversal when doing a revert.
-	go func() {
-		defer close(swapCh)
+	var toSwap uint64
+	if cp != nil {
+		if toSwap, err = cidlog.CountKeys(backupPath, cp.Swapped); err != nil {
+			return err
+		}
+		if err = l.SetOffset(cp.Swapped); err != nil {
+			return err
+		}
+	}
 
-		// Process backup file first.
-		for scanner.Scan() {
-			line := scanner.Text()
-			if len(line) == 0 {
-				continue
-			}
-			// The backup file only contains the original cidv1
-			// path. Do the path massaging magic to figure out the
-			// actual Cidv1 and derived Cidv0-path (current key).
-			cidPath := ds.NewKey(line)
-			cidKey := ds.NewKey(cidPath.BaseNamespace())
-			prefix := cidPath.Parent()
-			cid, err := dsKeyToCid(cidKey)
-			if err != nil {
-				log.Error("could not parse cid from backup file: %s", err)
-				scannerErr = err
-				break
-			}
-			mhashPath := prefix.Child(dshelp.MultihashToDsKey(cid.Hash()))
+	// The backup file contains prefixed keys, so we do not need to set
+	// Prefix in the CidSwapper. How many keys are reverted is not known
+	// until the pins and MFS have been walked.
+	prog := migrate.NewProgress("keys", toSwap)
+	defer prog.Done()
+	cidSwapper := CidSwapper{Store: m.dstore, Progress: prog, Blocks: m.blocks}
+	swap := cidSwapper.Run
+	if revert {
+		swap = cidSwapper.Revert
+	}
 
-			// The swapper will move cidPath to mhashPath, and the unswapper
-			// will do the opposite.
-			sw := Swap{Old: cidPath, New: mhashPath}
-			swapCh <- sw
+	// Process backup file first.
+	var total uint64
+	for {
+		entries, err := l.Next(swapChunk)
+		if err != nil {
+			log.Error("could not read backup file: %s", err)
+			return err
 		}
-		if err := scanner.Err(); err != nil {
+		if len(entries) == 0 {
+			break
+		}
+		done, err := swap(sendSwaps(swapsOf(entries)))
+		total += done
+		if err != nil {
 			log.Error(err)
-			return
+			return err
 		}
+		if cp != nil {
+			cp.Swapped = l.Offset()
+			if err := cp.save(); err != nil {
+				return err
+			}
+		}
+	}
 
-		if revert {
-			// Process MFS/pinset. We have to do this in cases the
-			// user has been running with the migration for some
-			// time and made changes to the pinset or the MFS
-			// root.
+	if revert {
+		// Process MFS/pinset. We have to do this in cases the
+		// user has been running with the migration for some
+		// time and made changes to the pinset or the MFS
+		// root.
+		swapCh := make(chan Swap, 1000)
+		go func() {
+			defer close(swapCh)
 			if err := walkPinsAndMFS(swapCh, m.dstore); err != nil {
 				log.Error(err)
-				return
 			}
+		}()
+		done, err := swap(swapCh)
+		total += done
+		if err != nil {
+			log.Error(err)
+			return err
 		}
-	}()
-
-	// The backup file contains prefixed keys, so we do not need to set
-	// Prefix in the CidSwapper.
-	cidSwapper := CidSwapper{Store: m.dstore}
-	var total uint64
-	if revert {
-		total, err = cidSwapper.Revert(swapCh)
-	} else {
-		total, err = cidSwapper.Run(swapCh)
-	}
-	if err != nil {
-		log.Error(err)
-		return err
-	}
-
-	// The swapper will only return after swapCh is closed, so we know
-	// scannerErr is safe to read at this point.
-	if scannerErr != nil {
-		return err
 	}
 
 	if revert {
-		log.Log("%d multihashes swapped to CidV1s", total)
+		log.Count("swapped", total, "%d multihashes swapped to CidV1s", total)
 	} else {
-		log.Log("%d CidV1s swapped to multihashes", total)
+		log.Count("swapped", total, "%d CidV1s swapped to multihashes", total)
 	}
 	return nil
 }
 
+// sendSwaps returns a channel that receives swaps and is then closed.
+func sendSwaps(swaps []Swap) <-chan Swap {
+	swapCh := make(chan Swap, 1000)
+	go func() {
+		defer close(swapCh)
+		for _, sw := range swaps {
+			swapCh <- sw
+		}
+	}()
+	return swapCh
+}
+
 // sends all pins in the pinset and the MFS root to the unswap channel when
 // they are CidV1s. This is used during revert. Pins may have been added or
 // MFS root changed at some point between the migration and the revert.  If we
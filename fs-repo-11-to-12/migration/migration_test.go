@@ -23,7 +23,7 @@ func TestGenericMigration(t *testing.T) {
 		EnableFlatFSFastPath = origSetting
 	}()
 	EnableFlatFSFastPath = false
-	testMigrationBase(t)
+	testMigrationBase(t, nil)
 }
 
 func TestFlatFSMigration(t *testing.T) {
@@ -32,7 +32,7 @@ func TestFlatFSMigration(t *testing.T) {
 		EnableFlatFSFastPath = origSetting
 	}()
 	EnableFlatFSFastPath = true
-	testMigrationBase(t)
+	testMigrationBase(t, nil)
 }
 
 // TestMigration works on an IPFS repository as created by running steps.sh
@@ -46,12 +46,18 @@ func TestFlatFSMigration(t *testing.T) {
 // added bafybeie4pduk2uwvr5dq36wnbhxspgox7dtqo3fprri4r2wpa7vrej5jqq b
 // added Qmesmmf1EEG1orJb6XdK6DabxexsseJnCfw8pqWgonbkoj c/file3
 // added QmT3zhz9ZZjEpbzWib95EQ5ESUQs4YasrMQwPScpNGLEXZ c
-func testMigrationBase(t *testing.T) {
+//
+// If blocksChild is set, the blocks are moved to the datastore it specifies
+// first, which then serves /blocks instead of flatfs.
+func testMigrationBase(t *testing.T, blocksChild map[string]interface{}) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Ensure we work on a clean copy of the provided repo
 	copyRepo(t)
+	if blocksChild != nil {
+		moveBlocks(t, blocksChild)
+	}
 
 	opts := migrate.Options{
 		Verbose: true,
@@ -66,6 +72,9 @@ func testMigrationBase(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	if blocksChild != nil && (m.blocks == nil || m.blocks.Type != blocksChild["type"]) {
+		t.Fatalf("/blocks is not served by %s alone: %+v", blocksChild["type"], m.blocks)
+	}
 
 	// Print out the blocks before migrating (for debugging)
 	origBlocks := blocks(t, m.dstore)
@@ -162,6 +171,13 @@ func testMigrationBase(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Re-open, as Revert() closes things.
+	err = m.open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.dstore.Close()
+
 	revertedBlocks := blocks(t, m.dstore)
 	t.Logf("Blocks post-revert: %d", len(revertedBlocks))
 
@@ -189,13 +205,6 @@ func testMigrationBase(t *testing.T) {
 		t.Log(revB, " -> ", c)
 	}
 
-	// Re-open, as Apply() closes things.
-	err = m.open(opts)
-	if err != nil {
-		t.Fatal(err)
-	}
-	defer m.dstore.Close()
-
 	// Check that the CIDv1s that we explicitally pinned or
 	// added to MFS are now retrievable as CIDv1-addressed nodes.
 	postMigrationCids := []cid.Cid{
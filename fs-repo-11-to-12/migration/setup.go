@@ -2,16 +2,10 @@ package mg11
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"io"
-	"os"
-	"path/filepath"
-	"reflect"
-	"sync"
-	"unsafe"
 
 	ipfslite "github.com/hsanjuan/ipfs-lite"
+	dsspec "github.com/ipfs/fs-repo-migrations/tools/dsspec"
 	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
 	lock "github.com/ipfs/fs-repo-migrations/tools/repolock"
 	log "github.com/ipfs/fs-repo-migrations/tools/stump"
@@ -19,12 +13,8 @@ import (
 	ds "github.com/ipfs/go-datastore"
 	ipfspinner "github.com/ipfs/go-ipfs-pinner"
 	"github.com/ipfs/go-ipfs-pinner/dspinner"
-	loader "github.com/ipfs/go-ipfs/plugin/loader"
 	fsrepo "github.com/ipfs/go-ipfs/repo/fsrepo"
 	format "github.com/ipfs/go-ipld-format"
-
-	"github.com/ipfs/go-datastore/mount"
-	flatfs "github.com/ipfs/go-ds-flatfs"
 )
 
 // locks the repo
@@ -33,34 +23,16 @@ func (m *Migration) lock(opts migrate.Options) (io.Closer, error) {
 	return lock.Lock2(opts.Path)
 }
 
-var loadPluginsOnce sync.Once
-
 // this is just setup so that we can open the datastore.
-// Plugins are loaded once only.
+// Plugins are loaded once only per process, with migrate.Once, as every
+// migration run in the process that is built with go-ipfs shares them.
 // Note: this means plugins cannot be loaded from multiple repos within the same binary
 // however, this does not seem relevant for migrations
 func setupPlugins(repoPath string) error {
-	var err error
-	var plugins *loader.PluginLoader
-	loadPluginsOnce.Do(func() {
+	return migrate.Once(migrate.IpfsPlugins, func() error {
 		log.VLog("  - loading repo configurations")
-		plugins, err = loader.NewPluginLoader(repoPath)
-		if err != nil {
-			err = fmt.Errorf("error loading plugins: %s", err)
-			return
-		}
-
-		if err = plugins.Initialize(); err != nil {
-			err = fmt.Errorf("error initializing plugins: %s", err)
-			return
-		}
-
-		if err = plugins.Inject(); err != nil {
-			err = fmt.Errorf("error injecting plugins: %s", err)
-			return
-		}
+		return loadPlugins(repoPath)
 	})
-	return err
 }
 
 // open the datastore and sets m.dstore. This opens it using whatever the
@@ -86,89 +58,38 @@ func (m *Migration) open(opts migrate.Options) error {
 		return err
 	}
 
-	dstore, err := dsc.Create(opts.Path)
+	blocks, ok, err := blocksBackend(opts.Path)
 	if err != nil {
 		return err
 	}
-	m.dstore = dstore
-	return nil
-}
-
-func getUnexportedField(field reflect.Value) interface{} {
-	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Interface()
-}
-
-func IsBasicFlatFSBlockstore(dstore ds.Datastore) (dsPath string, v1 *flatfs.ShardIdV1, err error) {
-	errNotSupportedFlatFSConfig := errors.New("not a supported FlatFS config")
-	defer func() {
-		if err := recover(); err != nil {
-			err = errNotSupportedFlatFSConfig
-		}
-	}()
-
-	mds, ok := dstore.(*mount.Datastore)
-	if !ok {
-		return "", nil, errNotSupportedFlatFSConfig
-	}
-
-	mnts, ok := getUnexportedField(reflect.ValueOf(mds).Elem().FieldByName("mounts")).([]mount.Mount)
-	if !ok {
-		return "", nil, errNotSupportedFlatFSConfig
-	}
-
-	if len(mnts) != 2 {
-		return "", nil, errNotSupportedFlatFSConfig
-	}
-
-	var blkDs ds.Datastore
-	if mnts[0].Prefix.Equal(blocksPrefix) {
-		blkDs = mnts[0].Datastore
-	} else if mnts[1].Prefix.Equal(blocksPrefix) {
-		blkDs = mnts[1].Datastore
-	} else {
-		return "", nil, errNotSupportedFlatFSConfig
-	}
-
-	if reflect.TypeOf(blkDs).String() != "*measure.measure" {
-		return "", nil, errNotSupportedFlatFSConfig
-	}
-
-	fsds, ok := getUnexportedField(reflect.ValueOf(blkDs).Elem().FieldByName("backend")).(*flatfs.Datastore)
-	if !ok {
-		return "", nil, errNotSupportedFlatFSConfig
+	m.blocks = nil
+	if ok {
+		m.blocks = &blocks
+		log.VLog("  - %s is served by %s datastore at %s", blocksPrefix, blocks.Type, blocks.Path)
 	}
-	fsdsPath := reflect.ValueOf(fsds).Elem().FieldByName("path").String()
 
-	shard, err := flatfs.ParseShardFunc(fsds.ShardStr())
+	dstore, err := dsc.Create(opts.Path)
 	if err != nil {
-		return "", nil, errNotSupportedFlatFSConfig
+		return err
 	}
-	return fsdsPath, shard, nil
+	m.dstore = dstore
+	return nil
 }
 
-// Create a file to store the list of migrated CIDs. If it exists, it is
-// opened for appending only.
-func createBackupFile(path, name string) (*os.File, error) {
-	backupPath := filepath.Join(path, name)
-	log.VLog("  - backup file will be written to %s", backupPath)
-	_, err := os.Stat(backupPath)
-	if err != nil && !os.IsNotExist(err) {
-		return nil, err
+// blocksBackend returns the backend serving the keys under /blocks in the
+// datastore of the repo at repoPath, as given by its datastore spec. ok is
+// false if they are spread over several backends, in which case only the
+// generic swapper can be used.
+func blocksBackend(repoPath string) (b dsspec.Backend, ok bool, err error) {
+	backends, err := dsspec.Load(repoPath)
+	if err != nil {
+		return b, false, err
 	}
-	// proceed without erroring when the file does not exist but warn
-	// if it already exists.
-	if err == nil {
-		log.Log("WARN: backup file %s already exists. CIDs-Multihash pairs will be appended", backupPath)
+	b, exclusive, ok := dsspec.Serving(backends, blocksPrefix.String())
+	if !ok || !exclusive {
+		return dsspec.Backend{}, false, nil
 	}
-
-	// Open for appending or create it.
-	return os.OpenFile(backupPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
-}
-
-// Open the backup file for reading.
-func getBackupFile(backupPath string) (*os.File, error) {
-	log.VLog("  - backup file will be read from %s", backupPath)
-	return os.Open(backupPath)
+	return b, true, nil
 }
 
 // get a pinner so we can pin things upon revert. It uses ipfs-lite to wrap
@@ -5,11 +5,12 @@ import (
 	flatfs "github.com/ipfs/go-ds-flatfs"
 	"os"
 	"path/filepath"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	dsspec "github.com/ipfs/fs-repo-migrations/tools/dsspec"
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
 	log "github.com/ipfs/fs-repo-migrations/tools/stump"
 	cid "github.com/ipfs/go-cid"
 	ds "github.com/ipfs/go-datastore"
@@ -19,48 +20,24 @@ import (
 )
 
 // SyncSize specifies how much we batch data before committing and syncing.
+// It is set by the -sync-size flag.
 var SyncSize uint64 = 100 * 1024 * 1024 // 100MiB
 
 // NWorkers sets the number of swapping threads to run when applying a
-// migration.
+// migration. It is set by the -workers flag.
 var NWorkers int = 1
 
+// EnableFlatFSFastPath makes the swappers rename the files of a basic flatfs
+// datastore instead of copying the blocks. It is set by the -flatfs-fast-path
+// flag.
 var EnableFlatFSFastPath bool = true
 
-func init() {
-	workerEnvVar := "IPFS_FS_MIGRATION_11_TO_12_NWORKERS"
-	syncSizeEnvVar := "IPFS_FS_MIGRATION_11_TO_12_SYNC_SIZE_BYTES"
-	flatfsFastPathEnvVar := "IPFS_FS_MIGRATION_11_TO_12_ENABLE_FLATFS_FASTPATH"
-	if nworkersStr, nworkerInEnv := os.LookupEnv(workerEnvVar); nworkerInEnv {
-		nworkers, err := strconv.Atoi(nworkersStr)
-		if err != nil {
-			panic(err)
-		}
-		if nworkers < 1 {
-			panic("number of workers must be at least 1")
-		}
-		NWorkers = nworkers
-	}
-
-	if syncSizeStr, syncSizeInEnv := os.LookupEnv(syncSizeEnvVar); syncSizeInEnv {
-		syncSize, err := strconv.ParseUint(syncSizeStr, 10, 64)
-		if err != nil {
-			panic(err)
-		}
-		if syncSize < 1 {
-			panic("sync size bytes must be at least 1")
-		}
-		SyncSize = syncSize
-	}
+// IORate is the number of bytes per second the swappers read and write at
+// most, or 0 for no limit. It is set by the -io-rate flag.
+var IORate uint64
 
-	if flatfsFastPathStr, flatfsFastPathInEnv := os.LookupEnv(flatfsFastPathEnvVar); flatfsFastPathInEnv {
-		enableFlatfsFastPath, err := strconv.ParseBool(flatfsFastPathStr)
-		if err != nil {
-			panic(err)
-		}
-		EnableFlatFSFastPath = enableFlatfsFastPath
-	}
-}
+// renameIOCost is what a flatfs rename counts for against IORate.
+const renameIOCost = 4096
 
 // Swap holds the datastore keys for the original CID and for the
 // destination Multihash.
@@ -75,6 +52,32 @@ type CidSwapper struct {
 	Prefix ds.Key      // A prefix/namespace to limit the query.
 	Store  ds.Batching // the datastore to migrate.
 	SwapCh chan Swap   // a channel that gets notified for every swap
+	// Progress, if set, is told about every swap made by Run and Revert.
+	Progress migrate.Progress
+	// Blocks, if set, is the backend that serves /blocks on its own. The
+	// swaps of the keys in a flatfs one are done by renaming its files, and
+	// in leveldb and badger with their batches.
+	Blocks *dsspec.Backend
+}
+
+// swapped records n swaps in cswap.Progress.
+func (cswap *CidSwapper) swapped(n uint64) {
+	if cswap.Progress != nil {
+		cswap.Progress.Add(n)
+	}
+}
+
+// notFound handles the swap sw, whose old key is not in the datastore. That
+// is expected when resuming an interrupted run, which swapped the keys after
+// the last checkpoint already: if the new key is there, sw is counted as
+// done. Otherwise the block is missing, and only skipped.
+func (cswap *CidSwapper) notFound(sw Swap) {
+	if has, err := cswap.Store.Has(sw.New); err == nil && has {
+		log.VLog("  %s was already swapped to %s", sw.Old, sw.New)
+		cswap.swapped(1)
+		return
+	}
+	log.Error("could not swap %s->%s. Could not find %s even though it was in the backup file. Skipping.", sw.Old, sw.New, sw.Old)
 }
 
 // Prepare performs a dry run without copying anything but notifying SwapCh
@@ -106,8 +109,9 @@ func (cswap *CidSwapper) Prepare() (uint64, error) {
 //
 // Run returns the total number of keys swapped.
 func (cswap *CidSwapper) Run(swapCh <-chan Swap) (uint64, error) {
+	limit := newRateLimiter(IORate)
 	swapWorkerFunc := func() (uint64, uint64) {
-		return cswap.swapWorker(swapCh, false) // reverting=false
+		return cswap.swapWorker(swapCh, false, limit) // reverting=false
 	}
 	return cswap.runWorkers(NWorkers, swapWorkerFunc)
 }
@@ -116,8 +120,9 @@ func (cswap *CidSwapper) Run(swapCh <-chan Swap) (uint64, error) {
 // receive Swap objects as they were sent by Run. It returns the number of
 // swap operations performed.
 func (cswap *CidSwapper) Revert(unswapCh <-chan Swap) (uint64, error) {
+	limit := newRateLimiter(IORate)
 	swapWorkerFunc := func() (uint64, uint64) {
-		return cswap.swapWorker(unswapCh, true) // reverting=true
+		return cswap.swapWorker(unswapCh, true, limit) // reverting=true
 	}
 	return cswap.runWorkers(NWorkers, swapWorkerFunc)
 }
@@ -192,7 +197,7 @@ func (cswap *CidSwapper) prepareWorker(resultsCh <-chan query.Result) (uint64, u
 	return sw.swapped, errored
 }
 
-func (cswap *CidSwapper) swapWorkerFlatFS(fsdsPath string, fsdsShard *flatfs.ShardIdV1, swapCh <-chan Swap, reverting bool) (uint64, uint64) {
+func (cswap *CidSwapper) swapWorkerFlatFS(fsdsPath string, fsdsShard *flatfs.ShardIdV1, swapCh <-chan Swap, reverting bool, limit *rateLimiter) (uint64, uint64) {
 	var swapped, errored uint64
 
 	const flatfsExtension = ".data"
@@ -210,11 +215,9 @@ func (cswap *CidSwapper) swapWorkerFlatFS(fsdsPath string, fsdsShard *flatfs.Sha
 	genericSwker := &swapWorker{
 		store:      cswap.Store,
 		syncPrefix: cswap.Prefix,
+		limit:      limit,
 	}
 
-	// the frequency with which we log flatfs moves
-	const swapLogThreshold = 10000
-
 	// Process keys from the results channel
 	for sw := range swapCh {
 		if reverting {
@@ -235,7 +238,7 @@ func (cswap *CidSwapper) swapWorkerFlatFS(fsdsPath string, fsdsShard *flatfs.Sha
 			// The datastore does not have the block we are planning to
 			// migrate.
 			if err == ds.ErrNotFound {
-				log.Error("could not swap %s->%s. Could not find %s even though it was in the backup file. Skipping.", sw.Old, sw.New, sw.Old)
+				cswap.notFound(sw)
 				continue
 			} else if err != nil {
 				log.Error("swapping %s->%s: %s", sw.Old, sw.New, err)
@@ -243,6 +246,7 @@ func (cswap *CidSwapper) swapWorkerFlatFS(fsdsPath string, fsdsShard *flatfs.Sha
 				continue
 			}
 
+			cswap.swapped(1)
 			if cswap.SwapCh != nil {
 				cswap.SwapCh <- Swap{Old: sw.Old, New: sw.New}
 			}
@@ -253,10 +257,20 @@ func (cswap *CidSwapper) swapWorkerFlatFS(fsdsPath string, fsdsShard *flatfs.Sha
 		newDir, newPath := getPath(fsdsPath, sw.New)
 
 		_, err := os.Stat(oldPath)
+		if os.IsNotExist(err) {
+			if _, err := os.Stat(newPath); err == nil {
+				log.VLog("  %s was already swapped to %s", sw.Old, sw.New)
+				cswap.swapped(1)
+				continue
+			}
+		}
 		if err != nil {
 			log.Error("could not swap %s->%s. Could not find %s even though it was in the backup file %s. Skipping.", sw.Old, sw.New, sw.Old, err.Error())
 			continue
 		}
+		// A rename only writes the directories, whatever the size of
+		// the file.
+		limit.wait(renameIOCost)
 
 		if err := os.Mkdir(newDir, 0755); err != nil && !os.IsExist(err) {
 			log.Error("could not swap %s->%s. Skipping.", sw.Old, sw.New, err.Error())
@@ -269,20 +283,14 @@ func (cswap *CidSwapper) swapWorkerFlatFS(fsdsPath string, fsdsShard *flatfs.Sha
 			continue
 		}
 		swapped++
-
-		if swapped%swapLogThreshold == 0 {
-			log.Log("%v: Migration worker has moved %d flatfs files and %d in total", time.Now(), swapLogThreshold, swapped)
-		}
+		cswap.swapped(1)
 
 		if cswap.SwapCh != nil {
 			cswap.SwapCh <- Swap{Old: sw.Old, New: sw.New}
 		}
 	}
 
-	// log the leftover flatfs moves that were not already logged
-	if rem := swapped % swapLogThreshold; rem != 0 {
-		log.Log("%v: Migration worker has moved %d flatfs files and %d in total", time.Now(), rem, swapped)
-	}
+	log.VLog("migration worker has moved %d flatfs files", swapped)
 
 	// handle generic worker sync
 	// final sync to added things
@@ -303,29 +311,39 @@ func (cswap *CidSwapper) swapWorkerFlatFS(fsdsPath string, fsdsShard *flatfs.Sha
 // unswap worker takes notifications from unswapCh (as they would be sent by
 // the swapWorker) and undoes them. It ignores NotFound errors so that reverts
 // can succeed even if they failed half-way.
-func (cswap *CidSwapper) swapWorker(swapCh <-chan Swap, reverting bool) (uint64, uint64) {
+func (cswap *CidSwapper) swapWorker(swapCh <-chan Swap, reverting bool, limit *rateLimiter) (uint64, uint64) {
+	// Leveldb and badger rewrite keys in bulk with their own batches.
+	if cswap.Blocks != nil && (cswap.Blocks.Type == leveldType || cswap.Blocks.Type == badgerType) {
+		return cswap.swapWorkerBatch(swapCh, reverting, limit)
+	}
+
 	// Use the more generic datastore swapper if the FlatFS fast path has been explicitly disabled
 	// Also use it for reversion since the FlatFS specific code doesn't specifically
 	// handle some reversion edge cases.
 	if !EnableFlatFSFastPath || reverting {
-		return cswap.swapWorkerDS(swapCh, reverting)
+		return cswap.swapWorkerDS(swapCh, reverting, limit)
 	}
 
 	// Use the more generic datastore swapper if not using a simple FlatFS setup.
-	fsdsPath, fsDsShard, err := IsBasicFlatFSBlockstore(cswap.Store)
+	if cswap.Blocks == nil || cswap.Blocks.Type != flatfsType || cswap.Blocks.Mountpoint != blocksPrefix.String() {
+		return cswap.swapWorkerDS(swapCh, reverting, limit)
+	}
+	fsDsShard, err := flatfs.ParseShardFunc(cswap.Blocks.ShardFunc)
 	if err != nil {
-		return cswap.swapWorkerDS(swapCh, reverting)
+		log.VLog("not using the flatfs fast path: %s", err)
+		return cswap.swapWorkerDS(swapCh, reverting, limit)
 	}
 
-	return cswap.swapWorkerFlatFS(fsdsPath, fsDsShard, swapCh, reverting)
+	return cswap.swapWorkerFlatFS(cswap.Blocks.Path, fsDsShard, swapCh, reverting, limit)
 }
 
-func (cswap *CidSwapper) swapWorkerDS(swapCh <-chan Swap, reverting bool) (uint64, uint64) {
+func (cswap *CidSwapper) swapWorkerDS(swapCh <-chan Swap, reverting bool, limit *rateLimiter) (uint64, uint64) {
 	var errored uint64
 
 	swker := &swapWorker{
 		store:      cswap.Store,
 		syncPrefix: cswap.Prefix,
+		limit:      limit,
 	}
 
 	// Process keys from the results channel
@@ -340,13 +358,14 @@ func (cswap *CidSwapper) swapWorkerDS(swapCh <-chan Swap, reverting bool) (uint6
 		// The datastore does not have the block we are planning to
 		// migrate.
 		if err == ds.ErrNotFound {
-			log.Error("could not swap %s->%s. Could not find %s even though it was in the backup file. Skipping.", sw.Old, sw.New, sw.Old)
+			cswap.notFound(sw)
 			continue
 		} else if err != nil {
 			log.Error("swapping %s->%s: %s", sw.Old, sw.New, err)
 			errored++
 			continue
 		}
+		cswap.swapped(1)
 		if cswap.SwapCh != nil {
 			cswap.SwapCh <- Swap{Old: sw.Old, New: sw.New}
 		}
@@ -375,6 +394,7 @@ type swapWorker struct {
 
 	store      ds.Batching
 	syncPrefix ds.Key
+	limit      *rateLimiter
 
 	toDelete []ds.Key
 }
@@ -388,6 +408,8 @@ func (sw *swapWorker) swap(old, new ds.Key, keepOld bool) error {
 	if err != nil {
 		return err
 	}
+	// The block is read and written again.
+	sw.limit.wait(2 * vLen)
 	if err := sw.store.Put(new, v); err != nil {
 		return err
 	}
@@ -428,7 +450,7 @@ func (sw *swapWorker) syncAndDelete() error {
 }
 
 func (sw *swapWorker) sync() error {
-	log.Log("%v: Generic migration worker syncing after %d objects migrated", time.Now(), sw.swapped)
+	log.Count("synced", sw.swapped, "%v: Generic migration worker syncing after %d objects migrated", time.Now(), sw.swapped)
 	err := sw.store.Sync(sw.syncPrefix)
 	if err != nil {
 		return err
@@ -0,0 +1,144 @@
+package mg11
+
+import (
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
+)
+
+// Set by "-workers auto" and "-sync-size auto".
+var autoWorkers, autoSyncSize bool
+
+// Bounds of the settings picked in auto mode.
+const (
+	maxAutoWorkers  = 16
+	minAutoSyncSize = 8 << 20
+	maxAutoSyncSize = 1 << 30
+)
+
+// fsyncProbes is the number of fsyncs timed to measure the fsync latency.
+const fsyncProbes = 8
+
+var tuneOnce sync.Once
+
+// tune picks NWorkers and SyncSize in auto mode, from the number of CPUs and
+// the fsync latency of the filesystem holding the repo. It only measures once.
+func tune(repoPath string) {
+	if !autoWorkers && !autoSyncSize {
+		return
+	}
+	tuneOnce.Do(func() {
+		latency, err := fsyncLatency(repoPath)
+		if err != nil {
+			log.Warn("could not measure the fsync latency, keeping the default settings: %s", err)
+			return
+		}
+
+		if autoWorkers {
+			// Disks that take long to sync are usually spinning, and
+			// slow down when read in several places at once.
+			NWorkers = 2
+			if latency < 2*time.Millisecond {
+				NWorkers = runtime.NumCPU()
+			}
+			if NWorkers > maxAutoWorkers {
+				NWorkers = maxAutoWorkers
+			}
+		}
+		if autoSyncSize {
+			// Write for about a hundred times as long as a sync
+			// takes, at 100MiB/s, so that syncing costs about 1%.
+			SyncSize = uint64(latency.Seconds() * 100 * (100 << 20))
+			if SyncSize < minAutoSyncSize {
+				SyncSize = minAutoSyncSize
+			}
+			if SyncSize > maxAutoSyncSize {
+				SyncSize = maxAutoSyncSize
+			}
+		}
+		log.Log("fsync takes %s, using %d workers and a sync size of %d bytes", latency, NWorkers, SyncSize)
+	})
+}
+
+// maxSettings returns NWorkers and SyncSize, or in auto mode the largest
+// values tune can pick for them.
+func maxSettings() (workers int, syncSize uint64) {
+	workers, syncSize = NWorkers, SyncSize
+	if autoWorkers {
+		workers = runtime.NumCPU()
+		if workers < 2 {
+			workers = 2
+		}
+		if workers > maxAutoWorkers {
+			workers = maxAutoWorkers
+		}
+	}
+	if autoSyncSize {
+		syncSize = maxAutoSyncSize
+	}
+	return workers, syncSize
+}
+
+// fsyncLatency returns the median time taken to write and sync a small file
+// in dir.
+func fsyncLatency(dir string) (time.Duration, error) {
+	f, err := ioutil.TempFile(dir, ".fsync-probe")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	buf := make([]byte, 4096)
+	times := make([]time.Duration, fsyncProbes)
+	for i := range times {
+		start := time.Now()
+		if _, err := f.WriteAt(buf, int64(i*len(buf))); err != nil {
+			return 0, err
+		}
+		if err := f.Sync(); err != nil {
+			return 0, err
+		}
+		times[i] = time.Since(start)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2], nil
+}
+
+// rateLimiter spreads IO over time so that it does not exceed a number of
+// bytes per second. It is shared by the workers of a CidSwapper. A nil
+// rateLimiter does not limit anything.
+type rateLimiter struct {
+	rate float64
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newRateLimiter(bytesPerSecond uint64) *rateLimiter {
+	if bytesPerSecond == 0 {
+		return nil
+	}
+	return &rateLimiter{rate: float64(bytesPerSecond)}
+}
+
+// wait blocks until n more bytes can be read or written.
+func (l *rateLimiter) wait(n uint64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(delay)
+}
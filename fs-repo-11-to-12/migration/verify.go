@@ -0,0 +1,318 @@
+package mg11
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/ipfs/fs-repo-migrations/fs-repo-11-to-12/migration/cidlog"
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+	mfsr "github.com/ipfs/fs-repo-migrations/tools/mfsr"
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dshelp "github.com/ipfs/go-ipfs-ds-help"
+	format "github.com/ipfs/go-ipld-format"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Commands returns the commands of the migration: verify-log and
+// verify-reachable.
+func (m *Migration) Commands() []migrate.Command {
+	return []migrate.Command{{
+		Name:  "verify-log",
+		Usage: "check the backup file of the migration, and the keys in it against the datastore, before reverting",
+		Run:   m.verifyLog,
+	}, {
+		Name:  "verify-reachable",
+		Usage: "check that every block of the pins and the MFS tree is stored under its multihash",
+		Run:   m.verifyReachableCmd,
+	}}
+}
+
+// verifyLog checks the backup file of the repo: its frames, checksum and
+// counts, that it was written for the repo, and where the keys it lists are
+// in the datastore. Keys found in neither form cannot be swapped back by
+// Revert, most likely because they were garbage collected since, and are
+// only warned about. It fails if the backup file does not verify.
+func (m *Migration) verifyLog(opts migrate.Options) error {
+	log.Verbose = opts.Verbose
+
+	lk, err := m.lock(opts)
+	if err != nil {
+		return err
+	}
+	defer lk.Close()
+
+	version, err := mfsr.RepoPath(opts.Path).Version()
+	if err != nil {
+		return err
+	}
+	hdr, err := newLogHeader(opts.Path)
+	if err != nil {
+		return err
+	}
+
+	backupPath := filepath.Join(opts.Path, backupFile)
+	name := backupFile
+	s, problems, err := cidlog.Check(backupPath, hdr.PeerID)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		log.Warn("backup file %s is in the text format of older versions: only its keys can be checked", name)
+	} else {
+		log.Log("backup file %s of repo %s, written %s, compression %s",
+			name, s.Header.PeerID, s.Header.Created.Format("2006-01-02 15:04:05 MST"), s.Header.Compression)
+		if s.Trailer != nil {
+			log.Log("  state: %s", s.Trailer.State)
+		}
+		var prefixes []string
+		for p := range s.Counts {
+			prefixes = append(prefixes, p)
+		}
+		sort.Strings(prefixes)
+		for _, p := range prefixes {
+			log.Log("  %s: %d keys", p, s.Counts[p])
+		}
+	}
+	for _, p := range problems {
+		log.Error("backup file %s: %s", name, p)
+	}
+
+	log.Phase("  - checking the keys against the datastore of the repo, at version %s", version)
+	if err := m.open(opts); err != nil {
+		return err
+	}
+	defer m.dstore.Close()
+
+	l, err := cidlog.Open(backupPath)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	var swapped, unswapped, missing uint64
+	for {
+		entries, err := l.Next(swapChunk)
+		if err != nil && len(problems) != 0 {
+			// Already reported.
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			break
+		}
+		for _, sw := range swapsOf(entries) {
+			hasNew, err := m.dstore.Has(sw.New)
+			if err != nil {
+				return err
+			}
+			hasOld, err := m.dstore.Has(sw.Old)
+			if err != nil {
+				return err
+			}
+			switch {
+			case hasNew:
+				swapped++
+			case hasOld:
+				unswapped++
+			default:
+				missing++
+				log.VLog("  %s is not in the datastore under either key", sw.Old)
+			}
+		}
+	}
+	log.Count("keys_swapped", swapped, "%d keys are stored under their multihash", swapped)
+	log.Count("keys_unswapped", unswapped, "%d keys are stored under their CIDv1", unswapped)
+	if missing != 0 {
+		log.Warn("%d keys are not in the datastore and cannot be reverted, most likely because they were garbage collected", missing)
+	}
+	if version == "12" && unswapped != 0 {
+		log.Warn("%d keys were not swapped although the repo is at version 12", unswapped)
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("backup file %s failed verification: %d problems", name, len(problems))
+	}
+	log.Log("backup file %s verified", name)
+	return nil
+}
+
+// VerifyReachable makes Apply run verifyReachable once the keys are swapped.
+// It walks every pinned DAG, which takes about as long as the migration on a
+// large repo. It is set by the -verify-reachable flag.
+var VerifyReachable = true
+
+// verifyReachableCmd runs verifyReachable on a repo at version 12.
+func (m *Migration) verifyReachableCmd(opts migrate.Options) error {
+	log.Verbose = opts.Verbose
+
+	lk, err := m.lock(opts)
+	if err != nil {
+		return err
+	}
+	defer lk.Close()
+
+	log.VLog("  - verifying version is '12'")
+	if err := mfsr.RepoPath(opts.Path).CheckVersion("12"); err != nil {
+		return err
+	}
+	if err := m.open(opts); err != nil {
+		return err
+	}
+	defer m.dstore.Close()
+	return m.verifyReachable()
+}
+
+// verifyReachable walks every recursive pin, and checks that each of its
+// blocks, and each directly pinned block, is stored under its multihash key,
+// where go-ipfs looks for it from version 12 on. Missing blocks are reported
+// with the pin they were reached from. The MFS tree is walked the same way,
+// but as it may refer to content that was never fetched, such as what
+// "ipfs files cp" links in, blocks absent from it are only warned about.
+// Blocks still stored under their CIDv1 key as well, which Apply should have
+// removed, are reported as duplicated. It fails if any pinned block is
+// unreachable, or any block of MFS is only stored under its CIDv1.
+func (m *Migration) verifyReachable() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	log.Phase("  - verifying that the blocks of pins and MFS are reachable")
+	pinner, dags, err := getPinner(ctx, m.dstore)
+	if err != nil {
+		return err
+	}
+	recursive, err := pinner.RecursiveKeys(ctx)
+	if err != nil {
+		return err
+	}
+	direct, err := pinner.DirectKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Pins are walked first, so that blocks both pinned and in MFS are
+	// checked as pinned.
+	w := &reachWalker{ctx: ctx, dstore: m.dstore, dags: dags, seen: cid.NewSet()}
+	for _, c := range recursive {
+		if err := w.walk(c, "recursive pin "+c.String(), true); err != nil {
+			return err
+		}
+	}
+	for _, c := range direct {
+		if err := w.walk(c, "direct pin "+c.String(), false); err != nil {
+			return err
+		}
+	}
+	mfsRoot, err := getMFSRoot(m.dstore)
+	switch {
+	case err == ds.ErrNotFound:
+		log.Warn("empty MFS root")
+	case err != nil:
+		return err
+	default:
+		w.mfs = true
+		if err := w.walk(mfsRoot, "the MFS root", true); err != nil {
+			return err
+		}
+	}
+
+	log.Count("reachable_blocks", w.blocks, "%d blocks of %d pins and MFS checked", w.blocks, len(recursive)+len(direct))
+	if w.duplicated != 0 {
+		log.Count("duplicated_blocks", w.duplicated, "%d blocks are stored under both their multihash and their CIDv1", w.duplicated)
+	}
+	if w.absent != 0 {
+		log.Count("absent_mfs_blocks", w.absent, "%d blocks of MFS are not in the repo", w.absent)
+		log.Warn("%d blocks of MFS are not in the repo, most likely because they were never fetched", w.absent)
+	}
+	if unreachable := w.missing + w.unreadable; unreachable != 0 {
+		log.Count("unreachable_blocks", unreachable, "%d blocks are unreachable", unreachable)
+		return fmt.Errorf("%d blocks of pins and MFS are unreachable: %d missing, %d unreadable", unreachable, w.missing, w.unreadable)
+	}
+	log.Log("every block of pins and MFS is reachable")
+	return nil
+}
+
+// reachWalker walks DAGs for verifyReachable, visiting each block once.
+type reachWalker struct {
+	ctx    context.Context
+	dstore ds.Batching
+	dags   format.DAGService
+	seen   *cid.Set
+	// mfs is set while walking the MFS tree, whose blocks need not be in
+	// the repo.
+	mfs bool
+
+	blocks, missing, duplicated, unreadable, absent uint64
+}
+
+// walk checks root, and when recursive, every block below it. from names
+// the root in reports.
+func (w *reachWalker) walk(root cid.Cid, from string, recursive bool) error {
+	stack := []cid.Cid{root}
+	for len(stack) != 0 {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if !w.seen.Visit(c) {
+			continue
+		}
+		w.blocks++
+
+		ok, err := w.check(c, from)
+		if err != nil {
+			return err
+		}
+		if !ok || !recursive {
+			continue
+		}
+		links, err := format.GetLinks(w.ctx, w.dags, c)
+		if err != nil {
+			w.unreadable++
+			log.Error("could not read the links of block %s, reachable from %s: %s", c, from, err)
+			continue
+		}
+		for _, l := range links {
+			stack = append(stack, l.Cid)
+		}
+	}
+	return nil
+}
+
+// check returns whether the block c is stored under its multihash key.
+func (w *reachWalker) check(c cid.Cid, from string) (bool, error) {
+	// Identity CIDs hold their data and are not stored.
+	if c.Prefix().MhType == mh.IDENTITY {
+		return true, nil
+	}
+	found, err := w.dstore.Has(blocksPrefix.Child(dshelp.MultihashToDsKey(c.Hash())))
+	if err != nil {
+		return false, err
+	}
+	// The key of a CIDv0 is its multihash key.
+	var underCid bool
+	if c.Version() == 1 {
+		if underCid, err = w.dstore.Has(blocksPrefix.Child(cidToDsKey(c))); err != nil {
+			return false, err
+		}
+	}
+
+	switch {
+	case found && underCid:
+		w.duplicated++
+		log.Warn("block %s is stored under both its multihash and its CIDv1", c)
+	case !found && underCid:
+		w.missing++
+		log.Error("block %s, reachable from %s, is only stored under its CIDv1: it was not swapped", c, from)
+	case !found && w.mfs:
+		w.absent++
+		log.VLog("  block %s, reachable from %s, is not in the repo", c, from)
+	case !found:
+		w.missing++
+		log.Error("block %s, reachable from %s, is missing", c, from)
+	}
+	return found, nil
+}
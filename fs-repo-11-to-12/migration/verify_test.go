@@ -0,0 +1,115 @@
+package mg11
+
+import (
+	"context"
+	"testing"
+
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+	mfsr "github.com/ipfs/fs-repo-migrations/tools/mfsr"
+	cid "github.com/ipfs/go-cid"
+	ipfspinner "github.com/ipfs/go-ipfs-pinner"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// TestVerifyReachable checks that blocks of MFS that are not in the repo are
+// only warned about, and that missing pinned blocks fail the check.
+func TestVerifyReachable(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	copyRepo(t)
+	opts := migrate.Options{
+		Verbose: true,
+		Flags: migrate.Flags{
+			Path:    workingRepo,
+			Verbose: true,
+		},
+	}
+	m := Migration{}
+	if err := m.Apply(opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.open(opts); err != nil {
+		t.Fatal(err)
+	}
+	defer m.dstore.Close()
+
+	if err := m.verifyReachable(); err != nil {
+		t.Fatalf("migrated repo: %s", err)
+	}
+
+	// Content linked into MFS with "ipfs files cp" need not be fetched.
+	hash, err := mh.Sum([]byte("never fetched"), mh.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	absent := cid.NewCidV1(cid.Raw, hash)
+	writeMFSRoot(t, m.dstore, absent)
+	if err := m.verifyReachable(); err != nil {
+		t.Fatalf("MFS root not in the repo: %s", err)
+	}
+
+	pinner, _, err := getPinner(ctx, m.dstore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinner.PinWithMode(absent, ipfspinner.Direct)
+	if err := pinner.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.verifyReachable(); err == nil {
+		t.Fatal("missing pinned block not reported")
+	}
+}
+
+// TestApplyUnreachable checks that Apply fails, with the repo at version 12,
+// when a pinned block is missing, unless VerifyReachable is unset.
+func TestApplyUnreachable(t *testing.T) {
+	defer func(v bool) { VerifyReachable = v }(VerifyReachable)
+	for _, verify := range []bool{true, false} {
+		VerifyReachable = verify
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		copyRepo(t)
+		opts := migrate.Options{
+			Verbose: true,
+			Flags: migrate.Flags{
+				Path:    workingRepo,
+				Verbose: true,
+			},
+		}
+		m := Migration{}
+		if err := m.open(opts); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := mh.Sum([]byte("never fetched"), mh.SHA2_256, -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pinner, _, err := getPinner(ctx, m.dstore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pinner.PinWithMode(cid.NewCidV1(cid.Raw, hash), ipfspinner.Direct)
+		if err := pinner.Flush(ctx); err != nil {
+			t.Fatal(err)
+		}
+		m.dstore.Close()
+
+		err = m.Apply(opts)
+		if verify && err == nil {
+			t.Error("missing pinned block did not fail the migration")
+		}
+		if !verify && err != nil {
+			t.Errorf("migration without verifying: %s", err)
+		}
+		version, err := mfsr.RepoPath(workingRepo).Version()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if version != "12" {
+			t.Errorf("repo at version %s, want 12", version)
+		}
+	}
+}
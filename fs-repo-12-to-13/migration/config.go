@@ -1,121 +1,95 @@
 package mg12
 
 import (
-	log "github.com/ipfs/fs-repo-migrations/tools/stump"
+	"strings"
+
+	"github.com/ipfs/fs-repo-migrations/tools/configmig"
 )
 
-// convertQuicAddrs converts quic multiaddrs to v1 and enables webtransport listener
-// https://github.com/ipfs/kubo/issues/9410
-// https://github.com/ipfs/kubo/issues/9292
-func convertQuicAddrs(confMap map[string]any) {
-	// run this first to avoid having both quic and quic-v1 webtransport addresses
-	runOnAllAddressFields(confMap, multiaddrPatternReplace(false, "/quic/webtransport", "/quic-v1/webtransport"))
+// addrFields are the fields holding multiaddrs that are converted.
+var addrFields = append(append([]string(nil), configmig.AddressFields...), "Swarm.AddrFilters")
 
-	runOnAllAddressFields(confMap, multiaddrPatternReplace(true, "/quic", "/quic-v1", "/p2p-circuit"))
-	runOnAllAddressFields(confMap, multiaddrPatternReplace(true, "/quic-v1", "/quic-v1/webtransport", "/p2p-circuit", "/webtransport"))
-}
+// transform describes the changes made to the config.
+var transform = configmig.Transform{
+	// convert quic multiaddrs to v1 and enable webtransport listener
+	// https://github.com/ipfs/kubo/issues/9410
+	// https://github.com/ipfs/kubo/issues/9292
+	//
+	// run this first to avoid having both quic and quic-v1 webtransport addresses
+	configmig.RewriteAddrs{
+		Fields:  addrFields,
+		Rewrite: replacePattern("/quic/webtransport", "/quic-v1/webtransport"),
+		Inverse: replacePattern("/quic-v1/webtransport", "/quic/webtransport"),
+		Mode:    configmig.Replace,
+	},
+	configmig.RewriteAddrs{
+		Fields:  addrFields,
+		Rewrite: replacePattern("/quic", "/quic-v1", "/p2p-circuit"),
+		Mode:    configmig.AddAfter,
+	},
+	configmig.RewriteAddrs{
+		Fields:  addrFields,
+		Rewrite: replacePattern("/quic-v1", "/quic-v1/webtransport", "/p2p-circuit", "/webtransport"),
+		Mode:    configmig.AddAfter,
+	},
 
-// convertRouting converts Routing.Type to implicit default
-// https://github.com/ipfs/kubo/pull/9475
-func convertRouting(confMap map[string]any) {
-	routing, _ := confMap["Routing"].(map[string]any)
-	if routing == nil {
-		log.Log("No Routing field in config, skipping")
-		return
-	}
+	// convert Routing.Type to implicit default
+	// https://github.com/ipfs/kubo/pull/9475
+	configmig.DropDefaults{
+		Path: "Routing",
+		Defaults: []configmig.Field{
+			{Key: "Type", Value: configmig.OneOf{"dht", ""}},
+		},
+		Unless: []string{"Routers", "Methods"},
+	},
 
-	routers, ok := routing["Routers"].(map[string]any)
-	if len(routers) > 0 {
-		log.Log("Custom Routing.Routers in config, skipping")
-		return
-	}
-	methods, ok := routing["Methods"].(map[string]any)
-	if len(methods) > 0 {
-		log.Log("Custom Routing.Methods in config, skipping")
-		return
-	}
+	// convert Reprovider to implicit defaults
+	// https://github.com/ipfs/kubo/pull/9326
+	configmig.DropDefaults{
+		Path: "Reprovider",
+		Defaults: []configmig.Field{
+			{Key: "Interval", Value: "12h"},
+			{Key: "Strategy", Value: "all"},
+		},
+	},
 
-	rType, ok := routing["Type"].(string)
-	if !ok {
-		log.Log("No Routing.Type field in config, skipping")
-		return
-	}
-	if rType == "dht" || rType == "" {
-		delete(routing, "Type")
-	} else {
-		log.Log("Routing.Type settings is different than the old default, skipping")
-	}
+	// convert Swarm.ConnMgr to implicit defaults
+	// https://github.com/ipfs/kubo/pull/9467
+	configmig.DropDefaults{
+		Path: "Swarm.ConnMgr",
+		Defaults: []configmig.Field{
+			{Key: "Type", Value: "basic"},
+			{Key: "LowWater", Value: 600},
+			{Key: "HighWater", Value: 900},
+			{Key: "GracePeriod", Value: "20s"},
+		},
+	},
 }
 
-// convertReprovider converts Reprovider to implicit defaults
-// https://github.com/ipfs/kubo/pull/9326
-func convertReprovider(confMap map[string]any) {
-	reprovider, _ := confMap["Reprovider"].(map[string]any)
-	if reprovider == nil {
-		log.Log("No Reprovider field in config, skipping")
-		return
-	}
-
-	interval, ok := reprovider["Interval"].(string)
-	if !ok {
-		log.Log("No Reprovider.Interval field in config, skipping")
-		return
-	}
-
-	strategy, ok := reprovider["Strategy"].(string)
-	if !ok {
-		log.Log("No Reprovider.Strategy field in config, skipping")
-		return
-	}
-
-	if interval == "12h" && strategy == "all" {
-		delete(reprovider, "Strategy")
-		delete(reprovider, "Interval")
-	} else {
-		log.Log("Reprovider settings are different than the old default, skipping")
+// replacePattern returns a function replacing the protocols old with new in
+// a multiaddr, scanning it from the end and stopping at any of the protocols
+// in notBefore.
+func replacePattern(old, new string, notBefore ...string) func(string) string {
+	return func(v string) string {
+		var r string
+		last := len(v)
+	ScanLoop:
+		for i := len(v); i != 0; {
+			i--
+			if hasPrefixAndEndsOrSlash(v[i:], old) {
+				r = new + v[i+len(old):last] + r
+				last = i
+			}
+			for _, not := range notBefore {
+				if hasPrefixAndEndsOrSlash(v[i:], not) {
+					break ScanLoop
+				}
+			}
+		}
+		return v[:last] + r
 	}
 }
 
-// convertConnMgr converts Swarm.ConnMgr to implicit defaults
-// https://github.com/ipfs/kubo/pull/9467
-func convertConnMgr(confMap map[string]any) {
-	swarm, _ := confMap["Swarm"].(map[string]any)
-	if swarm == nil {
-		log.Log("No Swarm field in config, skipping")
-		return
-	}
-	connmgr, _ := swarm["ConnMgr"].(map[string]any)
-	if connmgr == nil {
-		log.Log("No Swarm.ConnMgr field in config, skipping")
-		return
-	}
-	cmType, ok := connmgr["Type"].(string)
-	if !ok {
-		log.Log("No Swarm.ConnMgr.Type field in config, skipping")
-		return
-	}
-	cmLowWater, ok := connmgr["LowWater"].(float64)
-	if !ok {
-		log.Log("No Swarm.ConnMgr.LowWater field in config, skipping")
-		return
-	}
-	cmHighWater, ok := connmgr["HighWater"].(float64)
-	if !ok {
-		log.Log("No Swarm.ConnMgr.HighWater field in config, skipping")
-		return
-	}
-	cmGrace, ok := connmgr["GracePeriod"].(string)
-	if !ok {
-		log.Log("No Swarm.ConnMgr.GracePeriod field in config, skipping")
-		return
-	}
-
-	if cmType == "basic" && int(cmLowWater) == 600 && int(cmHighWater) == 900 && cmGrace == "20s" {
-		delete(connmgr, "Type")
-		delete(connmgr, "GracePeriod")
-		delete(connmgr, "LowWater")
-		delete(connmgr, "HighWater")
-	} else {
-		log.Log("Swarm.ConnMgr settings are different than the old defaults, skipping")
-	}
+func hasPrefixAndEndsOrSlash(s, prefix string) bool {
+	return strings.HasPrefix(s, prefix) && (len(prefix) == len(s) || s[len(prefix)] == '/')
 }
@@ -5,6 +5,8 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+
+	"github.com/ipfs/fs-repo-migrations/tools/configmig"
 )
 
 var beforeDefaultConfig = `{
@@ -181,11 +183,24 @@ func TestCustomConfigMigration(t *testing.T) {
 	testConfigMigration(customConfig, customConfig, t)
 }
 
+func TestAddrFieldsCopied(t *testing.T) {
+	// Appending to configmig.AddressFields could change it for every other
+	// user of it, if it has room to grow.
+	for _, f := range configmig.AddressFields {
+		if f == "Swarm.AddrFilters" {
+			t.Fatal("Swarm.AddrFilters added to configmig.AddressFields")
+		}
+	}
+	if len(addrFields) == 0 || &addrFields[0] == &configmig.AddressFields[0] {
+		t.Fatal("addrFields shares its array with configmig.AddressFields")
+	}
+}
+
 func testConfigMigration(beforeConfig string, afterConfig string, t *testing.T) string {
 	in := strings.NewReader(beforeConfig)
 	out := new(bytes.Buffer)
 
-	err := convert(in, out)
+	err := transform.Convert(in, out)
 	if err != nil {
 		t.Fatal(err)
 	}
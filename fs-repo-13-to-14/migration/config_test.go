@@ -0,0 +1,139 @@
+package mg13
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var beforeDefaultConfig = `{
+  "Experimental": {
+    "AcceleratedDHTClient": true
+  },
+  "Routing": {
+    "Type": "dht"
+  }
+}`
+
+var afterDefaultConfig = `{
+  "Routing": {
+    "AcceleratedDHTClient": true,
+    "Type": "dht"
+  }
+}`
+
+var beforeOtherExperimentsConfig = `{
+  "Experimental": {
+    "AcceleratedDHTClient": false,
+    "FilestoreEnabled": true
+  }
+}`
+
+var afterOtherExperimentsConfig = `{
+  "Experimental": {
+    "FilestoreEnabled": true
+  },
+  "Routing": {
+    "AcceleratedDHTClient": false
+  }
+}`
+
+var beforeMissingConfig = `{
+  "Identity": {}
+}`
+
+var afterMissingConfig = `{
+  "Identity": {},
+  "Routing": {
+    "AcceleratedDHTClient": false
+  }
+}`
+
+var alreadyMovedConfig = `{
+  "Experimental": {
+    "AcceleratedDHTClient": false
+  },
+  "Routing": {
+    "AcceleratedDHTClient": true
+  }
+}`
+
+var afterAlreadyMovedConfig = `{
+  "Routing": {
+    "AcceleratedDHTClient": true
+  }
+}`
+
+func TestDefaultConfigMigration(t *testing.T) {
+	out := testConfigMigration(beforeDefaultConfig, afterDefaultConfig, t)
+	testConfigRevert(out, beforeDefaultConfig, t)
+}
+
+func TestDefaultConfigMigrationIdempotency(t *testing.T) {
+	out1 := testConfigMigration(beforeDefaultConfig, afterDefaultConfig, t)
+	out2 := testConfigMigration(out1, afterDefaultConfig, t)
+	if out1 != out2 {
+		t.Fatalf("Config migration expected to be idempotent. Mismatch\nSecond conversion produced:\n%s\nExpected:\n%s\n", out2, out1)
+	}
+}
+
+func TestOtherExperimentsConfigMigration(t *testing.T) {
+	// other experimental flags are left in place
+	out := testConfigMigration(beforeOtherExperimentsConfig, afterOtherExperimentsConfig, t)
+	testConfigRevert(out, beforeOtherExperimentsConfig, t)
+}
+
+func TestMissingConfigMigration(t *testing.T) {
+	// a config without the flag gets the default
+	testConfigMigration(beforeMissingConfig, afterMissingConfig, t)
+}
+
+func TestAlreadyMovedConfigMigration(t *testing.T) {
+	// the value already under Routing wins
+	testConfigMigration(alreadyMovedConfig, afterAlreadyMovedConfig, t)
+}
+
+func TestBadTypeConfigMigration(t *testing.T) {
+	in := strings.NewReader(`{"Experimental": {"AcceleratedDHTClient": "yes"}}`)
+	if err := transform.Convert(in, new(bytes.Buffer)); err == nil {
+		t.Fatal("expected an error for a non-bool AcceleratedDHTClient")
+	}
+}
+
+func testConfigMigration(beforeConfig string, afterConfig string, t *testing.T) string {
+	in := strings.NewReader(beforeConfig)
+	out := new(bytes.Buffer)
+
+	err := transform.Convert(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forward := out.String()
+	if noSpace(forward) != noSpace(afterConfig) {
+		t.Fatalf("Mismatch\nConversion produced:\n%s\nExpected:\n%s\n", forward, afterConfig)
+	}
+	return forward
+}
+
+func testConfigRevert(afterConfig string, beforeConfig string, t *testing.T) {
+	in := strings.NewReader(afterConfig)
+	out := new(bytes.Buffer)
+
+	err := transform.ConvertBack(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	back := out.String()
+	if noSpace(back) != noSpace(beforeConfig) {
+		t.Fatalf("Mismatch\nRevert produced:\n%s\nExpected:\n%s\n", back, beforeConfig)
+	}
+}
+
+var whitespaceRe = regexp.MustCompile(`\s`)
+
+func noSpace(str string) string {
+	return whitespaceRe.ReplaceAllString(str, "")
+}
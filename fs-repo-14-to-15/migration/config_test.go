@@ -0,0 +1,149 @@
+package mg14
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var beforeDefaultConfig = `{
+  "Addresses": {
+    "Announce": [],
+    "AppendAnnounce": [
+      "/ip4/2.0.0.0/udp/4001/quic"
+    ],
+    "NoAnnounce": [
+      "/ip4/1.0.0.0/udp/4001/quic",
+      "/ip4/1.0.0.0/udp/4001/quic-v1"
+    ],
+    "Swarm": [
+      "/ip4/0.0.0.0/tcp/4001",
+      "/ip6/::/tcp/4001",
+      "/ip4/0.0.0.0/udp/4001/quic",
+      "/ip4/0.0.0.0/udp/4001/quic/webtransport",
+      "/ip6/::/udp/4001/quic"
+    ]
+  },
+  "Bootstrap": [
+    "/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+    "/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+    "/ip4/104.131.131.82/udp/4001/quic/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+  ],
+  "Gateway": {
+    "HTTPHeaders": {
+      "Access-Control-Allow-Headers": [
+        "X-Requested-With",
+        "Range",
+        "User-Agent"
+      ],
+      "Access-Control-Allow-Methods": [
+        "GET"
+      ],
+      "Access-Control-Allow-Origin": [
+        "*"
+      ]
+    }
+  }
+}`
+
+var afterDefaultConfig = `{
+  "Addresses": {
+    "Announce": null,
+    "AppendAnnounce": [
+      "/ip4/2.0.0.0/udp/4001/quic-v1"
+    ],
+    "NoAnnounce": [
+      "/ip4/1.0.0.0/udp/4001/quic-v1"
+    ],
+    "Swarm": [
+      "/ip4/0.0.0.0/tcp/4001",
+      "/ip6/::/tcp/4001",
+      "/ip4/0.0.0.0/udp/4001/quic-v1",
+      "/ip4/0.0.0.0/udp/4001/quic-v1/webtransport",
+      "/ip6/::/udp/4001/quic-v1"
+    ]
+  },
+  "Bootstrap": [
+    "/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt",
+    "/ip4/104.131.131.82/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+    "/ip4/104.131.131.82/udp/4001/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+  ],
+  "Gateway": {
+    "HTTPHeaders": {}
+  }
+}`
+
+var customConfig = `{
+  "Addresses": {
+    "Swarm": [
+      "/ip4/0.0.0.0/tcp/4001",
+      "/ip4/0.0.0.0/udp/4001/quic-v1"
+    ]
+  },
+  "Bootstrap": [
+    "/dnsaddr/bootstrap.libp2p.io/p2p/QmcZf59bWwK5XFi76CZX8cbJ4BhTzzA3gU1ZjYZcYW3dwt"
+  ],
+  "Gateway": {
+    "HTTPHeaders": {
+      "Access-Control-Allow-Methods": [
+        "GET",
+        "POST"
+      ],
+      "Access-Control-Allow-Origin": [
+        "https://example.com"
+      ]
+    }
+  }
+}`
+
+func TestDefaultConfigMigration(t *testing.T) {
+	testConfigMigration(beforeDefaultConfig, afterDefaultConfig, t)
+}
+
+func TestDefaultConfigMigrationIdempotency(t *testing.T) {
+	out1 := testConfigMigration(beforeDefaultConfig, afterDefaultConfig, t)
+	out2 := testConfigMigration(out1, afterDefaultConfig, t)
+	if out1 != out2 {
+		t.Fatalf("Config migration expected to be idempotent. Mismatch\nSecond conversion produced:\n%s\nExpected:\n%s\n", out2, out1)
+	}
+}
+
+func TestCustomConfigMigration(t *testing.T) {
+	// user config with custom values for migrated fields is left untouched
+	testConfigMigration(customConfig, customConfig, t)
+}
+
+func TestBadTypeConfigMigration(t *testing.T) {
+	// address lists of the wrong type are skipped, a Bootstrap list of the
+	// wrong type fails the migration
+	skipped := `{"Addresses": {"Swarm": "/ip4/0.0.0.0/udp/4001/quic"}}`
+	testConfigMigration(skipped, skipped, t)
+
+	in := strings.NewReader(`{"Bootstrap": "/ip4/104.131.131.82/udp/4001/quic"}`)
+	if err := transform.Convert(in, new(bytes.Buffer)); err == nil {
+		t.Fatal("expected an error for a non-array Bootstrap")
+	}
+}
+
+func testConfigMigration(beforeConfig string, afterConfig string, t *testing.T) string {
+	in := strings.NewReader(beforeConfig)
+	out := new(bytes.Buffer)
+
+	err := transform.Convert(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forward := out.String()
+	if noSpace(forward) != noSpace(afterConfig) {
+		t.Fatalf("Mismatch\nConversion produced:\n%s\nExpected:\n%s\n", forward, afterConfig)
+	}
+	return forward
+}
+
+var whitespaceRe = regexp.MustCompile(`\s`)
+
+func noSpace(str string) string {
+	return whitespaceRe.ReplaceAllString(str, "")
+}
@@ -3,3 +3,5 @@ module github.com/ipfs/fs-repo-migrations/fs-repo-15-to-16
 go 1.22
 
 require github.com/ipfs/fs-repo-migrations/tools v0.0.0-20211209222258-754a2dcb82ea
+
+replace github.com/ipfs/fs-repo-migrations/tools => ../tools
@@ -0,0 +1,165 @@
+package mg15
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var beforeDefaultConfig = `{
+  "Addresses": {
+    "Announce": [],
+    "AppendAnnounce": [
+      "/ip4/2.0.0.0/udp/4001/quic-v1"
+    ],
+    "NoAnnounce": [
+      "/ip4/1.0.0.0/udp/4001/quic-v1",
+      "/ip4/1.0.0.0/udp/4001/webrtc-direct"
+    ],
+    "Swarm": [
+      "/ip4/0.0.0.0/tcp/4001",
+      "/ip6/::/tcp/4001",
+      "/ip4/0.0.0.0/udp/4001/quic-v1",
+      "/ip4/0.0.0.0/udp/4001/quic-v1/webtransport",
+      "/ip6/::/udp/4001/quic-v1",
+      "/ip6/::/udp/4001/quic-v1/webtransport"
+    ]
+  },
+  "Swarm": {
+    "AddrFilters": [
+      "/ip4/10.0.0.0/udp/4001/quic-v1"
+    ]
+  }
+}`
+
+var afterDefaultConfig = `{
+  "Addresses": {
+    "Announce": null,
+    "AppendAnnounce": [
+      "/ip4/2.0.0.0/udp/4001/webrtc-direct",
+      "/ip4/2.0.0.0/udp/4001/quic-v1"
+    ],
+    "NoAnnounce": [
+      "/ip4/1.0.0.0/udp/4001/webrtc-direct",
+      "/ip4/1.0.0.0/udp/4001/quic-v1"
+    ],
+    "Swarm": [
+      "/ip4/0.0.0.0/tcp/4001",
+      "/ip6/::/tcp/4001",
+      "/ip4/0.0.0.0/udp/4001/webrtc-direct",
+      "/ip4/0.0.0.0/udp/4001/quic-v1",
+      "/ip4/0.0.0.0/udp/4001/quic-v1/webtransport",
+      "/ip6/::/udp/4001/webrtc-direct",
+      "/ip6/::/udp/4001/quic-v1",
+      "/ip6/::/udp/4001/quic-v1/webtransport"
+    ]
+  },
+  "Swarm": {
+    "AddrFilters": [
+      "/ip4/10.0.0.0/udp/4001/quic-v1"
+    ]
+  }
+}`
+
+// revertedDefaultConfig is beforeDefaultConfig once reverted: the
+// /webrtc-direct address that was already there is removed with the added
+// ones, and the empty list stays null.
+var revertedDefaultConfig = `{
+  "Addresses": {
+    "Announce": null,
+    "AppendAnnounce": [
+      "/ip4/2.0.0.0/udp/4001/quic-v1"
+    ],
+    "NoAnnounce": [
+      "/ip4/1.0.0.0/udp/4001/quic-v1"
+    ],
+    "Swarm": [
+      "/ip4/0.0.0.0/tcp/4001",
+      "/ip6/::/tcp/4001",
+      "/ip4/0.0.0.0/udp/4001/quic-v1",
+      "/ip4/0.0.0.0/udp/4001/quic-v1/webtransport",
+      "/ip6/::/udp/4001/quic-v1",
+      "/ip6/::/udp/4001/quic-v1/webtransport"
+    ]
+  },
+  "Swarm": {
+    "AddrFilters": [
+      "/ip4/10.0.0.0/udp/4001/quic-v1"
+    ]
+  }
+}`
+
+var customConfig = `{
+  "Addresses": {
+    "Swarm": [
+      "/ip4/0.0.0.0/tcp/4001",
+      "/ip4/0.0.0.0/udp/4001/quic"
+    ]
+  }
+}`
+
+func TestDefaultConfigMigration(t *testing.T) {
+	out := testConfigMigration(beforeDefaultConfig, afterDefaultConfig, t)
+	testConfigRevert(out, revertedDefaultConfig, t)
+}
+
+func TestDefaultConfigMigrationIdempotency(t *testing.T) {
+	out1 := testConfigMigration(beforeDefaultConfig, afterDefaultConfig, t)
+	out2 := testConfigMigration(out1, afterDefaultConfig, t)
+	if out1 != out2 {
+		t.Fatalf("Config migration expected to be idempotent. Mismatch\nSecond conversion produced:\n%s\nExpected:\n%s\n", out2, out1)
+	}
+}
+
+func TestCustomConfigMigration(t *testing.T) {
+	// config without QUIC v1 addresses is left untouched
+	testConfigMigration(customConfig, customConfig, t)
+}
+
+func TestBadTypeConfigMigration(t *testing.T) {
+	// .Addresses and address lists of the wrong type are skipped
+	for _, cfg := range []string{
+		`{"Addresses": "/ip4/0.0.0.0/udp/4001/quic-v1"}`,
+		`{"Addresses": {"Swarm": {"a": "/ip4/0.0.0.0/udp/4001/quic-v1"}}}`,
+	} {
+		testConfigMigration(cfg, cfg, t)
+	}
+}
+
+func testConfigMigration(beforeConfig string, afterConfig string, t *testing.T) string {
+	in := strings.NewReader(beforeConfig)
+	out := new(bytes.Buffer)
+
+	err := transform.Convert(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forward := out.String()
+	if noSpace(forward) != noSpace(afterConfig) {
+		t.Fatalf("Mismatch\nConversion produced:\n%s\nExpected:\n%s\n", forward, afterConfig)
+	}
+	return forward
+}
+
+func testConfigRevert(afterConfig string, beforeConfig string, t *testing.T) {
+	in := strings.NewReader(afterConfig)
+	out := new(bytes.Buffer)
+
+	err := transform.ConvertBack(in, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	back := out.String()
+	if noSpace(back) != noSpace(beforeConfig) {
+		t.Fatalf("Mismatch\nRevert produced:\n%s\nExpected:\n%s\n", back, beforeConfig)
+	}
+}
+
+var whitespaceRe = regexp.MustCompile(`\s`)
+
+func noSpace(str string) string {
+	return whitespaceRe.ReplaceAllString(str, "")
+}
@@ -3,3 +3,5 @@ module github.com/ipfs/fs-repo-migrations/fs-repo-2-to-3
 go 1.15
 
 require github.com/ipfs/fs-repo-migrations/tools v0.0.0-20210323144402-297a63449538
+
+replace github.com/ipfs/fs-repo-migrations/tools => ../tools
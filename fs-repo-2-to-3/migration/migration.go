@@ -38,6 +38,20 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Converts the pins, stored as lists of keys in the datastore, to pin sets stored as blocks.",
+		Areas:   []string{migrate.AreaPins},
+	}
+}
+
+// Estimate returns the size of the leveldb datastore the pins are read from.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	_, bytes, err := migrate.DirUsage(path.Join(repoPath, "datastore"), "")
+	return migrate.Cost{Bytes: bytes, Note: "size of the leveldb datastore the pins are read from"}, err
+}
+
 func (m Migration) Apply(opts migrate.Options) error {
 	log.Verbose = opts.Verbose
 	log.Log("applying %s repo migration", m.Versions())
@@ -95,9 +109,7 @@ func (m Migration) Revert(opts migrate.Options) error {
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("lowered version number to 2")
-	}
+	log.VLog("lowered version number to 2")
 
 	return nil
 }
@@ -314,7 +326,7 @@ func indirectPins(pinner newpin.Pinner, dserv dag.DAGService) (map[u.Key]int, er
 }
 
 func writeOldIndirPins(to dstore.Datastore, k dstore.Key, pins map[u.Key]int) error {
-	fmt.Println("INDIRECT PINS: ", pins)
+	log.VLog("  - indirect pins: %v", pins)
 	refs := make(map[string]int)
 	for k, v := range pins {
 		refs[k.String()] = int(v)
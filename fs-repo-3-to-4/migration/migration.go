@@ -2,13 +2,11 @@ package mg3
 
 import (
 	"encoding/hex"
-	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
-	"time"
 
 	blocks "github.com/ipfs/fs-repo-migrations/fs-repo-3-to-4/Godeps/_workspace/src/github.com/ipfs/go-ipfs/blocks"
 	util "github.com/ipfs/fs-repo-migrations/fs-repo-3-to-4/Godeps/_workspace/src/github.com/ipfs/go-ipfs/util"
@@ -37,6 +35,20 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Re-encodes the keys of the blocks, public keys and IPNS records in the datastore.",
+		Areas:   []string{migrate.AreaBlocks, migrate.AreaIPNS},
+	}
+}
+
+// Estimate counts the blocks in the flatfs datastore, which are all renamed.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	n, bytes, err := migrate.DirUsage(filepath.Join(repoPath, "blocks"), ".data")
+	return migrate.Cost{Items: n, Unit: "blocks", Bytes: bytes}, err
+}
+
 type validFunc func(string) bool
 type mkKeyFunc func(util.Key) dstore.Key
 type txFunc func(dstore.Datastore, dstore.Key, []byte, mkKeyFunc) error
@@ -179,9 +191,7 @@ func (m Migration) Revert(opts migrate.Options) error {
 	if err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("lowered version number to 3")
-	}
+	log.VLog("lowered version number to 3")
 
 	return nil
 }
@@ -247,12 +257,13 @@ func rewriteKeys(oldds, newds dstore.Datastore, pref string, mkKey mkKeyFunc, va
 
 	log.Log("got %d keys, beginning transfer. This will take some time.", len(entries))
 
-	prog := NewProgress(len(entries))
+	prog := migrate.NewProgress("keys", uint64(len(entries)))
+	var skipped int
 	for _, e := range entries {
-		prog.Next()
+		prog.Add(1)
 
 		if !valid(e.Key) {
-			prog.Skip()
+			skipped++
 			continue
 		}
 
@@ -278,7 +289,10 @@ func rewriteKeys(oldds, newds dstore.Datastore, pref string, mkKey mkKeyFunc, va
 			return err
 		}
 	}
-	fmt.Println()
+	prog.Done()
+	if skipped > 0 {
+		log.Log("skipped %d invalid keys", skipped)
+	}
 
 	return nil
 }
@@ -325,16 +339,8 @@ func revertIpnsEntries(ds dstore.Datastore, oldk dstore.Key, data []byte, mkkey
 
 func transferBlocks(flatfsdir string) error {
 	var keys []string
-	dots := 0
+	log.Phase("  - enumerating keys")
 	filepath.Walk(flatfsdir, func(p string, i os.FileInfo, err error) error {
-		if dots%200 == 0 {
-			fmt.Printf("\r                         \renumerating keys")
-		}
-		if dots%40 == 39 {
-			fmt.Printf(".")
-		}
-		dots++
-
 		if i.IsDir() {
 			return nil
 		}
@@ -349,7 +355,7 @@ func transferBlocks(flatfsdir string) error {
 
 		rel := p[len(flatfsdir)+1:]
 		if !strings.HasSuffix(rel, ".data") {
-			fmt.Println("skipping (no .data): ", rel)
+			log.VLog("skipping (no .data): %s", rel)
 			return nil
 		}
 
@@ -357,22 +363,21 @@ func transferBlocks(flatfsdir string) error {
 		return nil
 	})
 
-	prog := NewProgress(len(keys))
+	prog := migrate.NewProgress("keys", uint64(len(keys)))
 	for _, p := range keys {
-		prog.Next()
+		prog.Add(1)
 		rel := p[len(flatfsdir)+1:]
 
 		justkey := rel[:len(rel)-5]
 		if validateNewKey(justkey) {
-			prog.Skip()
-			fmt.Printf("skipping %s, already in new format\n", justkey)
+			log.VLog("skipping %s, already in new format", justkey)
 			continue
 		}
 
 		_, fi := filepath.Split(rel[:len(rel)-5])
 		k, err := hex.DecodeString(fi)
 		if err != nil {
-			fmt.Printf("failed to decode: %s\n", p)
+			log.Error("failed to decode: %s", p)
 			return err
 		}
 
@@ -400,12 +405,11 @@ func transferBlocks(flatfsdir string) error {
 			return err
 		}
 	}
-
-	fmt.Println()
+	prog.Done()
 
 	err := cleanEmptyDirs(flatfsdir)
 	if err != nil {
-		fmt.Println(err)
+		log.Error(err)
 	}
 
 	return nil
@@ -437,39 +441,3 @@ func cleanEmptyDirs(dir string) error {
 	}
 	return nil
 }
-
-type progress struct {
-	total   int
-	current int
-	skipped int
-
-	start time.Time
-}
-
-func NewProgress(total int) *progress {
-	return &progress{
-		total: total,
-		start: time.Now(),
-	}
-}
-
-func (p *progress) Skip() {
-	p.skipped++
-}
-
-func (p *progress) Next() {
-	p.current++
-	fmt.Printf("\r[%d / %d]", p.current, p.total)
-	if p.skipped > 0 {
-		fmt.Printf(" (skipped: %d)", p.skipped)
-	}
-
-	if p.current%10 == 9 {
-		took := time.Now().Sub(p.start)
-		av := took / time.Duration(p.current)
-		estim := av * time.Duration(p.total-p.current)
-		est := strings.Split(estim.String(), ".")[0]
-
-		fmt.Printf("  Approx time remaining: %ss  ", est)
-	}
-}
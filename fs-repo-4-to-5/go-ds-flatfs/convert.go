@@ -6,7 +6,6 @@ package flatfs
 import (
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -49,7 +48,14 @@ func DowngradeV1toV0(path string) error {
 	return nil
 }
 
-func Move(oldPath string, newPath string, out io.Writer) error {
+// Progress is told about the keys moved by Move.
+type Progress interface {
+	Add(n uint64)
+}
+
+// Move moves the keys of the datastore at oldPath to the one at newPath,
+// reporting each to progress unless it is nil.
+func Move(oldPath string, newPath string, progress Progress) error {
 	oldDS, err := Open(oldPath, false)
 	if err != nil {
 		return fmt.Errorf("%s: %v", oldPath, err)
@@ -64,12 +70,7 @@ func Move(oldPath string, newPath string, out io.Writer) error {
 		return err
 	}
 
-	if out != nil {
-		fmt.Fprintf(out, "Moving Keys...\n")
-	}
-
 	// first move the keys
-	count := 0
 	for {
 		e, ok := res.NextSync()
 		if !ok {
@@ -84,16 +85,11 @@ func Move(oldPath string, newPath string, out io.Writer) error {
 			return err
 		}
 
-		count++
-		if out != nil && count%10 == 0 {
-			fmt.Fprintf(out, "\r%d keys so far", count)
+		if progress != nil {
+			progress.Add(1)
 		}
 	}
 
-	if out != nil {
-		fmt.Fprintf(out, "\nCleaning Up...\n")
-	}
-
 	// now walk the old top-level directory
 	dir, err := os.Open(oldDS.path)
 	if err != nil {
@@ -160,10 +156,6 @@ func Move(oldPath string, newPath string, out io.Writer) error {
 		}
 	}
 
-	if out != nil {
-		fmt.Fprintf(out, "All Done.\n")
-	}
-
 	return nil
 }
 
@@ -2,10 +2,9 @@ package mg3
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
 
 	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
 	mfsr "github.com/ipfs/fs-repo-migrations/tools/mfsr"
@@ -25,11 +24,47 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
-func revertStep2(ffspath string) error {
-	if err := flatfs.DowngradeV1toV0(ffspath); err != nil {
-		return fmt.Errorf("reverting flatfsv1 upgrade: %s", err)
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Moves the blocks of the flatfs datastore from the prefix/5 to the next-to-last/2 sharding.",
+		Areas:   []string{migrate.AreaBlocks},
 	}
-	return nil
+}
+
+// Estimate counts the blocks in the flatfs datastore, which are all moved to
+// blocks-v5 and back.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	n, bytes, err := migrate.DirUsage(filepath.Join(repoPath, "blocks"), ".data")
+	return migrate.Cost{Items: n, Unit: "blocks", Bytes: bytes}, err
+}
+
+// Preflight counts the shard directories of blocks-v5. The blocks are renamed
+// into them, so only the directories take extra space until the old ones are
+// removed.
+func (m Migration) Preflight(repoPath string) (migrate.Space, error) {
+	ffspath := filepath.Join(repoPath, "blocks")
+	if _, err := os.Stat(ffspath); os.IsNotExist(err) {
+		// an interrupted migration may have renamed it already
+		ffspath = filepath.Join(repoPath, "blocks-v4")
+	}
+	shard := flatfs.NextToLast(2).Func()
+
+	dirs := make(map[string]struct{})
+	err := filepath.Walk(ffspath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".data") {
+			dirs[shard(strings.TrimSuffix(fi.Name(), ".data"))] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return migrate.Space{}, err
+	}
+	// blocks-v5 itself holds SHARDING and _README besides the shards.
+	return migrate.Space{Path: repoPath, Files: 2, Dirs: int64(len(dirs)) + 1}, nil
 }
 
 func (m Migration) Apply(opts migrate.Options) error {
@@ -50,143 +85,184 @@ func (m Migration) Apply(opts migrate.Options) error {
 		return err
 	}
 
-	basepath := filepath.Join(opts.Path, "blocks")
-	ffspath := filepath.Join(opts.Path, "blocks-v4")
-	if err := os.Rename(basepath, ffspath); err != nil {
-		// the error returned is unreliable so instead check that the
-		// old path doesn't exist and the new one does and is a directory
-		if _, err2 := os.Stat(basepath); os.IsNotExist(err2) {
-			if fi, err2 := os.Stat(ffspath); err2 == nil && fi.IsDir() {
-				log.Log("... blocks already renamed to blocks-v4, continuing")
-				err = nil
-			}
-		}
-		if err != nil {
-			return err
-		}
+	if opts.DryRun {
+		return dryRun(opts.Path)
 	}
 
-	revert1 := func(e error) error {
-		if opts.NoRevert {
-			return e
-		}
-		err := os.Rename(ffspath, basepath)
-		if err != nil {
-			log.Error(err)
-		}
-		return e
-	}
-
-	log.Log("> Upgrading datastore format to have sharding specification file")
-	if err := flatfs.UpgradeV0toV1(ffspath, 5); err != nil {
-		if os.IsExist(err) {
-			id, err2 := flatfs.ReadShardFunc(ffspath)
-			if err2 == nil && id.String() == flatfs.Prefix(5).String() {
-				log.Log("... datastore already has sharding specification file, continuing")
-				err = nil
-			}
-		}
-		if err != nil {
-			return revert1(err)
-		}
+	j, err := migrate.OpenJournal(opts.Path, m.Versions(), false)
+	if err != nil {
+		return err
 	}
 
+	basepath := filepath.Join(opts.Path, "blocks")
+	ffspath := filepath.Join(opts.Path, "blocks-v4")
 	tempffs := filepath.Join(opts.Path, "blocks-v5")
-	log.Log("> creating a new flatfs datastore with new format")
-	if err := flatfs.Create(tempffs, flatfs.NextToLast(2)); err != nil {
-		if err == flatfs.ErrDatastoreExists {
-			log.Log("... new flatfs datastore already exists continuing")
-			err = nil
-		}
-		if err != nil {
-			if err2 := revertStep2(ffspath); err2 != nil {
-				log.Error(err2)
-			}
-			return revert1(err)
-		}
-	}
 
-	revert3 := func(mainerr error) error {
-		log.Error("failed to convert flatfs datastore: %s", mainerr)
-		if opts.NoRevert {
-			return mainerr
-		}
-		log.Log("attempting to revert...")
-
-		if _, err := os.Stat(filepath.Join(ffspath, "SHARDING")); os.IsNotExist(err) {
-			flatfs.UpgradeV0toV1(ffspath, 5)
-		}
-
-		if err := flatfs.Move(tempffs, ffspath, os.Stdout); err != nil {
-			log.Error("reverting flatfs conversion failed: %s", err)
-			log.Error("Please file a bug report at https://github.com/ipfs/fs-repo-migrations")
-			return err
-		}
+	err = j.Run([]migrate.Step{
+		{
+			Name: "rename blocks to blocks-v4",
+			Do: func() error {
+				return rename(basepath, ffspath)
+			},
+			Undo: func() error {
+				return rename(ffspath, basepath)
+			},
+		},
+		{
+			Name: "add sharding specification file",
+			Do: func() error {
+				log.Phase("> Upgrading datastore format to have sharding specification file")
+				err := flatfs.UpgradeV0toV1(ffspath, 5)
+				if os.IsExist(err) {
+					id, err2 := flatfs.ReadShardFunc(ffspath)
+					if err2 == nil && id.String() == flatfs.Prefix(5).String() {
+						log.Log("... datastore already has sharding specification file, continuing")
+						err = nil
+					}
+				}
+				return err
+			},
+			Undo: func() error {
+				if _, err := os.Stat(filepath.Join(ffspath, "SHARDING")); os.IsNotExist(err) {
+					return nil
+				}
+				if err := flatfs.DowngradeV1toV0(ffspath); err != nil {
+					return fmt.Errorf("reverting flatfsv1 upgrade: %s", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "create blocks-v5",
+			Do: func() error {
+				log.Phase("> creating a new flatfs datastore with new format")
+				err := flatfs.Create(tempffs, flatfs.NextToLast(2))
+				if err == flatfs.ErrDatastoreExists {
+					log.Log("... new flatfs datastore already exists continuing")
+					err = nil
+				}
+				return err
+			},
+		},
+		{
+			Name: "move blocks to blocks-v5",
+			Do: func() error {
+				log.Phase("> converting current flatfs datastore to new format")
+				return moveBlocks(ffspath, tempffs)
+			},
+			Undo: func() error {
+				if _, err := os.Stat(filepath.Join(ffspath, "SHARDING")); os.IsNotExist(err) {
+					flatfs.UpgradeV0toV1(ffspath, 5)
+				}
+				if err := moveBlocks(tempffs, ffspath); err != nil {
+					return fmt.Errorf("reverting flatfs conversion failed: %s", err)
+				}
+				if err := os.Remove(tempffs); err != nil {
+					log.Error("cleaning up temp flatfs directory: %s", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "remove blocks-v4",
+			Do: func() error {
+				log.Phase("> moving new datastore into place")
+				err := os.Remove(ffspath)
+				if err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("removing supposedly empty old flatfs dir: %s", err)
+				}
+				return nil
+			},
+			Undo: func() error {
+				err := os.Mkdir(ffspath, 0755)
+				if err != nil && !os.IsExist(err) {
+					return fmt.Errorf("recreating flatfs directory: %s", err)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "rename blocks-v5 to blocks",
+			Do: func() error {
+				log.Phase("> moving transferred datastore back into place")
+				if err := rename(tempffs, basepath); err != nil {
+					return fmt.Errorf("moving new datastore into place of the old one: %s", err)
+				}
+				return nil
+			},
+			Undo: func() error {
+				return rename(basepath, tempffs)
+			},
+		},
+	}, opts.NoRevert)
+	if err != nil {
+		return err
+	}
 
-		if err := os.Remove(tempffs); err != nil {
-			log.Error("cleaning up temp flatfs directory: %s", err)
-		}
+	log.Log("Migration 4 to 5 succeeded")
+	return nil
+}
 
-		if err := revertStep2(ffspath); err != nil {
-			log.Error(err)
+// rename renames oldpath to newpath, unless that was done already. The
+// error returned by os.Rename is unreliable, so instead check that the old
+// path does not exist and the new one does.
+func rename(oldpath, newpath string) error {
+	err := os.Rename(oldpath, newpath)
+	if err != nil {
+		if _, err2 := os.Stat(oldpath); os.IsNotExist(err2) {
+			if _, err2 := os.Stat(newpath); err2 == nil {
+				log.Log("... %s already renamed to %s, continuing", filepath.Base(oldpath), filepath.Base(newpath))
+				return nil
+			}
 		}
-
-		return revert1(mainerr)
-	}
-
-	log.Log("> converting current flatfs datastore to new format")
-	if err := flatfs.Move(ffspath, tempffs, os.Stdout); err != nil {
-		return revert3(err)
 	}
+	return err
+}
 
-	log.Log("> moving new datastore into place")
-	if err := os.Remove(ffspath); err != nil {
-		return revert3(fmt.Errorf("removing supposedly empty old flatfs dir: %s", err))
+// dryRun reports every block that Apply would move from the prefix/5 flatfs
+// layout into the next-to-last/2 layout, without changing anything.
+func dryRun(repoPath string) error {
+	ffspath := filepath.Join(repoPath, "blocks")
+	if _, err := os.Stat(ffspath); os.IsNotExist(err) {
+		// an interrupted migration may have renamed it already
+		ffspath = filepath.Join(repoPath, "blocks-v4")
 	}
+	shard := flatfs.NextToLast(2).Func()
 
-	revert4 := func(mainerr error) error {
-		if opts.NoRevert {
-			return mainerr
-		}
-		if err := os.Mkdir(ffspath, 0755); err != nil {
-			log.Error("recreating flatfs directory: %s", err)
+	log.Log("dry run: blocks that would be moved in %s", ffspath)
+	var count, size int64
+	err := filepath.Walk(ffspath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
 			return err
 		}
-
-		return revert3(mainerr)
-	}
-
-	log.Log("> moving transferred datastore back into place")
-	if err := os.Rename(tempffs, basepath); err != nil {
-		return revert4(fmt.Errorf("moving new datastore into place of the old one: %s", err))
-	}
-
-	err = repo.WriteVersion("5")
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".data") {
+			return nil
+		}
+		key := strings.TrimSuffix(fi.Name(), ".data")
+		oldDir := filepath.Base(filepath.Dir(p))
+		log.Log("  /%s: %s -> %s", key, oldDir, shard(key))
+		count++
+		size += fi.Size()
+		return nil
+	})
 	if err != nil {
-		log.Error("failed to update version file to 5")
 		return err
 	}
 
-	log.Log("updated version file")
-
-	log.Log("Migration 4 to 5 succeeded")
+	log.Count("blocks", uint64(count), "dry run: %d blocks (%d bytes) would be moved", count, size)
 	return nil
 }
 
-func writePhase(file string, phase int) error {
-	return ioutil.WriteFile(file, []byte(fmt.Sprint(phase)), 0666)
-}
-
-func readPhase(file string) (int, error) {
-	data, err := ioutil.ReadFile(file)
+// moveBlocks moves the blocks of the flatfs datastore at from to the one at
+// to, reporting its progress.
+func moveBlocks(from, to string) error {
+	n, _, err := migrate.DirUsage(from, ".data")
 	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, nil
-		}
-		return 0, err
+		return err
 	}
-
-	return strconv.Atoi(string(data))
+	prog := migrate.NewProgress("blocks", uint64(n))
+	defer prog.Done()
+	return flatfs.Move(from, to, prog)
 }
 
 func (m Migration) Revert(opts migrate.Options) error {
@@ -203,57 +279,82 @@ func (m Migration) Revert(opts migrate.Options) error {
 		return err
 	}
 
-	phasefile := filepath.Join(opts.Path, "revert-phase")
-	basepath := filepath.Join(opts.Path, "blocks")
-	v5path := filepath.Join(opts.Path, "blocks-v5")
-	v4path := filepath.Join(opts.Path, "blocks-v4")
-
-	phase, err := readPhase(phasefile)
+	j, err := migrate.OpenJournal(opts.Path, m.Versions(), true)
 	if err != nil {
-		return fmt.Errorf("reading revert phase: %s", err)
+		return err
 	}
 
-	for ; phase < 6; phase++ {
-		switch phase {
-		case 0:
-			if err := os.Rename(basepath, v5path); err != nil {
-				return err
-			}
-		case 1:
-			if err := flatfs.Create(v4path, flatfs.Prefix(5)); err != nil {
-				return err
-			}
+	basepath := filepath.Join(opts.Path, "blocks")
+	v5path := filepath.Join(opts.Path, "blocks-v5")
+	v4path := filepath.Join(opts.Path, "blocks-v4")
 
-		case 2:
-			if err := flatfs.Move(v5path, v4path, os.Stdout); err != nil {
+	steps := []migrate.Step{
+		{
+			Name: "rename blocks to blocks-v5",
+			Do: func() error {
+				return rename(basepath, v5path)
+			},
+			Undo: func() error {
+				return rename(v5path, basepath)
+			},
+		},
+		{
+			Name: "create blocks-v4",
+			Do: func() error {
+				err := flatfs.Create(v4path, flatfs.Prefix(5))
+				if err == flatfs.ErrDatastoreExists {
+					err = nil
+				}
 				return err
-			}
-
-		case 3:
-			if err := flatfs.DowngradeV1toV0(v4path); err != nil {
+			},
+		},
+		{
+			Name: "move blocks to blocks-v4",
+			Do: func() error {
+				return moveBlocks(v5path, v4path)
+			},
+			Undo: func() error {
+				return moveBlocks(v4path, v5path)
+			},
+		},
+		{
+			Name: "remove sharding specification file",
+			Do: func() error {
+				if _, err := os.Stat(filepath.Join(v4path, "SHARDING")); os.IsNotExist(err) {
+					return nil
+				}
+				return flatfs.DowngradeV1toV0(v4path)
+			},
+			Undo: func() error {
+				err := flatfs.UpgradeV0toV1(v4path, 5)
+				if os.IsExist(err) {
+					err = nil
+				}
 				return err
-			}
+			},
+		},
+		{
+			Name: "rename blocks-v4 to blocks",
+			Do: func() error {
+				return rename(v4path, basepath)
+			},
+			Undo: func() error {
+				return rename(basepath, v4path)
+			},
+		},
+	}
 
-		case 4:
-			if err := os.Rename(v4path, basepath); err != nil {
-				return err
-			}
+	// Earlier versions of this migration kept track of the revert in a
+	// "revert-phase" file, with one phase per step.
+	if err := j.ImportPhaseFile(filepath.Join(opts.Path, "revert-phase"), steps); err != nil {
+		return err
+	}
 
-		case 5:
-			err = repo.WriteVersion("4")
-			if err != nil {
-				return err
-			}
-			if opts.Verbose {
-				fmt.Println("lowered version number to 4")
-			}
-		}
-		if err := writePhase(phasefile, phase+1); err != nil {
-			return err
-		}
+	if err := j.Run(steps, opts.NoRevert); err != nil {
+		return err
 	}
+	log.VLog("lowered version number to 4")
 	os.Remove(v5path)
-	os.Remove(phasefile)
 
 	return nil
 }
@@ -7,6 +7,8 @@ import (
 	"io/ioutil"
 	"os"
 	"reflect"
+
+	log "github.com/ipfs/fs-repo-migrations/tools/stump"
 )
 
 // convFunc does an inplace conversion of the "datastore"
@@ -124,7 +126,7 @@ func ver6to5(ds ciConfig) (err error) {
 	ds.set("Type", "leveldb")
 	ds.set("Params", nil)
 	ds.set("NoSync", !sync)
-	println("ver6to5 done!")
+	log.VLog("converted the datastore config to version 5")
 	return nil
 }
 
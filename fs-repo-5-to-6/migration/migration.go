@@ -1,11 +1,10 @@
 package mg5
 
 import (
-	"fmt"
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strconv"
 
 	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
 	mfsr "github.com/ipfs/fs-repo-migrations/tools/mfsr"
@@ -23,6 +22,20 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Replaces the datastore settings of the config with Datastore.Spec, and writes the datastore_spec file that go-ipfs checks it against.",
+		Areas:   []string{migrate.AreaConfig, migrate.AreaDatastoreSpec},
+	}
+}
+
+// Estimate returns the cost of the migration, which only rewrites the config
+// and writes datastore_spec.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	return migrate.ConfigCost(repoPath)
+}
+
 func (m Migration) Apply(opts migrate.Options) error {
 	log.Verbose = opts.Verbose
 	log.Log("applying %s repo migration", m.Versions())
@@ -41,83 +54,97 @@ func (m Migration) Apply(opts migrate.Options) error {
 		return err
 	}
 
-	basepath := filepath.Join(opts.Path, "config")
-	v5path := filepath.Join(opts.Path, "config-v5")
-	if err := os.Rename(basepath, v5path); err != nil {
-		if os.IsNotExist(err) {
-			_, err2 := os.Stat(v5path)
-			if err2 == nil {
-				log.Log("... config already renamed to config-v5, continuing")
-				err = nil
-			}
-		}
-		if err != nil {
-			return err
-		}
+	j, err := migrate.OpenJournal(opts.Path, m.Versions(), false)
+	if err != nil {
+		return err
 	}
 
-	revert1 := func(e error) error {
-		if opts.NoRevert {
-			return e
-		}
-		err := os.Rename(v5path, basepath)
-		if err != nil {
-			log.Error(err)
-		}
-		return e
+	basepath := filepath.Join(opts.Path, "config")
+	v5path := filepath.Join(opts.Path, "config-v5")
+	specpath := filepath.Join(opts.Path, "datastore_spec")
+
+	err = j.Run([]migrate.Step{
+		{
+			Name: "rename config to config-v5",
+			Do: func() error {
+				return rename(basepath, v5path)
+			},
+			Undo: func() error {
+				return rename(v5path, basepath)
+			},
+		},
+		{
+			Name: "convert config",
+			Do: func() error {
+				log.Phase("> Upgrading config to new format")
+				_, err := convertFile(v5path, basepath, ver5to6)
+				return err
+			},
+			Undo: func() error {
+				return remove(basepath)
+			},
+		},
+		{
+			Name: "write datastore_spec",
+			Do: func() error {
+				return writeDatastoreSpec(basepath, specpath)
+			},
+			Undo: func() error {
+				return remove(specpath)
+			},
+		},
+	}, opts.NoRevert)
+	if err != nil {
+		return err
 	}
 
-	log.Log("> Upgrading config to new format")
+	log.Log("Migration 5 to 6 succeeded")
+	return nil
+}
 
-	cfg, err := convertFile(v5path, basepath, ver5to6)
+// writeDatastoreSpec writes the datastore_spec file at specpath for the
+// version 6 config at cfgpath.
+func writeDatastoreSpec(cfgpath, specpath string) error {
+	data, err := ioutil.ReadFile(cfgpath)
 	if err != nil {
-		if err != nil {
-			return revert1(err)
-		}
+		return err
+	}
+	confMap := make(map[string]interface{})
+	if err = json.Unmarshal(data, &confMap); err != nil {
+		return err
 	}
+	cfg := newCiConfig(confMap)
 
 	// if any part of this is nil it is a programmer error
 	dsc, err := AnyDatastoreConfig(
 		newCiConfig(cfg.get("datastore").(map[string]interface{})).
 			get("spec").(map[string]interface{}))
 	if err != nil {
-		return revert1(err)
-	}
-
-	err = ioutil.WriteFile(
-		filepath.Join(opts.Path, "datastore_spec"),
-		dsc.DiskSpec().Bytes(),
-		0600)
-	if err != nil {
-		return revert1(err)
-	}
-
-	err = repo.WriteVersion("6")
-	if err != nil {
-		log.Error("failed to update version file to 6")
 		return err
 	}
 
-	log.Log("updated version file")
-
-	log.Log("Migration 5 to 6 succeeded")
-	return nil
-}
-
-func writePhase(file string, phase int) error {
-	return ioutil.WriteFile(file, []byte(fmt.Sprint(phase)), 0666)
+	return ioutil.WriteFile(specpath, dsc.DiskSpec().Bytes(), 0600)
 }
 
-func readPhase(file string) (int, error) {
-	data, err := ioutil.ReadFile(file)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, nil
+// rename renames oldpath to newpath, unless that was done already.
+func rename(oldpath, newpath string) error {
+	err := os.Rename(oldpath, newpath)
+	if os.IsNotExist(err) {
+		if _, err2 := os.Stat(newpath); err2 == nil {
+			log.Log("... %s already renamed to %s, continuing", filepath.Base(oldpath), filepath.Base(newpath))
+			return nil
 		}
-		return 0, err
 	}
+	return err
+}
 
-	return strconv.Atoi(string(data))
+// remove removes the file at path, if it exists.
+func remove(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
 }
 
 func (m Migration) Revert(opts migrate.Options) error {
@@ -134,42 +161,56 @@ func (m Migration) Revert(opts migrate.Options) error {
 		return err
 	}
 
-	phasefile := filepath.Join(opts.Path, "revert-phase")
+	j, err := migrate.OpenJournal(opts.Path, m.Versions(), true)
+	if err != nil {
+		return err
+	}
+
 	basepath := filepath.Join(opts.Path, "config")
 	v6path := filepath.Join(opts.Path, "config-v6")
+	specpath := filepath.Join(opts.Path, "datastore_spec")
+
+	steps := []migrate.Step{
+		{
+			Name: "rename config to config-v6",
+			Do: func() error {
+				return rename(basepath, v6path)
+			},
+			Undo: func() error {
+				return rename(v6path, basepath)
+			},
+		},
+		{
+			Name: "convert config",
+			Do: func() error {
+				_, err := convertFile(v6path, basepath, ver6to5)
+				return err
+			},
+			Undo: func() error {
+				return remove(basepath)
+			},
+		},
+		{
+			Name: "remove datastore_spec",
+			Do: func() error {
+				return remove(specpath)
+			},
+			Undo: func() error {
+				return writeDatastoreSpec(v6path, specpath)
+			},
+		},
+	}
 
-	phase, err := readPhase(phasefile)
-	if err != nil {
-		return fmt.Errorf("reading revert phase: %s", err)
+	// Earlier versions of this migration kept track of the revert in a
+	// "revert-phase" file, with one phase per step.
+	if err := j.ImportPhaseFile(filepath.Join(opts.Path, "revert-phase"), steps); err != nil {
+		return err
 	}
 
-	for ; phase < 4; phase++ {
-		switch phase {
-		case 0:
-			if err := os.Rename(basepath, v6path); err != nil {
-				return err
-			}
-		case 1:
-			if _, err := convertFile(v6path, basepath, ver6to5); err != nil {
-				return err
-			}
-		case 2:
-			if err := os.Remove(filepath.Join(opts.Path, "datastore_spec")); err != nil {
-				return err
-			}
-		case 3:
-			if err := repo.WriteVersion("5"); err != nil {
-				return err
-			}
-			if opts.Verbose {
-				fmt.Println("lowered version number to 5")
-			}
-		}
-		if err := writePhase(phasefile, phase+1); err != nil {
-			return err
-		}
+	if err := j.Run(steps, opts.NoRevert); err != nil {
+		return err
 	}
-	os.Remove(phasefile)
+	log.VLog("lowered version number to 5")
 
 	return nil
 }
@@ -6,3 +6,5 @@ require (
 	github.com/ipfs/fs-repo-migrations/tools v0.0.0-20210323144402-297a63449538
 	golang.org/x/net v0.0.0-20201207224615-747e23833adb
 )
+
+replace github.com/ipfs/fs-repo-migrations/tools => ../tools
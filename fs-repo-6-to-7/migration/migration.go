@@ -2,6 +2,7 @@ package mg6
 
 import (
 	"fmt"
+	"path/filepath"
 
 	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
 	log "github.com/ipfs/fs-repo-migrations/tools/stump"
@@ -30,6 +31,21 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Copies the IPNS records of the node and of the keys in the keystore out of their DHT record envelope, to the keys go-ipfs now reads them from.",
+		Areas:   []string{migrate.AreaIPNS},
+	}
+}
+
+// Estimate counts the keys whose IPNS record is rewritten: the keys in the
+// keystore and the key of the node.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	n, _, err := migrate.DirUsage(filepath.Join(repoPath, "keystore"), "")
+	return migrate.Cost{Items: n + 1, Unit: "keys"}, err
+}
+
 func myKey(r repo.Repo) (ci.PrivKey, error) {
 	cfg, err := r.Config()
 	if err != nil {
@@ -23,6 +23,19 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Replaces the default bootstrap peers in the config with the new ones.",
+		Areas:   []string{migrate.AreaConfig},
+	}
+}
+
+// Estimate returns the cost of the migration, which only rewrites the config.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	return migrate.ConfigCost(repoPath)
+}
+
 func (m Migration) Apply(opts migrate.Options) error {
 	log.Verbose = opts.Verbose
 	log.Log("applying %s repo migration", m.Versions())
@@ -133,9 +146,7 @@ func (m Migration) Revert(opts migrate.Options) error {
 			if err := repo.WriteVersion("7"); err != nil {
 				return err
 			}
-			if opts.Verbose {
-				fmt.Println("lowered version number to 7")
-			}
+			log.VLog("lowered version number to 7")
 		}
 		if err := writePhase(phasefile, phase+1); err != nil {
 			return err
@@ -23,6 +23,20 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Encodes the file names in the keystore in base32, so that key names are not limited by the filesystem.",
+		Areas:   []string{migrate.AreaKeystore},
+	}
+}
+
+// Estimate counts the files in the keystore, which are all renamed.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	n, bytes, err := migrate.DirUsage(filepath.Join(repoPath, "keystore"), "")
+	return migrate.Cost{Items: n, Unit: "keys", Bytes: bytes}, err
+}
+
 const keyFilenamePrefix = "key_"
 
 const keystoreRoot = "keystore"
@@ -23,6 +23,19 @@ func (m Migration) Reversible() bool {
 	return true
 }
 
+// Describe says what the migration does.
+func (m Migration) Describe() migrate.Description {
+	return migrate.Description{
+		Summary: "Adds QUIC to the swarm addresses and the default bootstrap peers in the config.",
+		Areas:   []string{migrate.AreaConfig},
+	}
+}
+
+// Estimate returns the cost of the migration, which only rewrites the config.
+func (m Migration) Estimate(repoPath string) (migrate.Cost, error) {
+	return migrate.ConfigCost(repoPath)
+}
+
 func (m Migration) Apply(opts migrate.Options) error {
 	log.Verbose = opts.Verbose
 	log.Log("applying %s repo migration", m.Versions())
@@ -92,9 +105,7 @@ func (m Migration) Revert(opts migrate.Options) error {
 	if err := repo.WriteVersion("9"); err != nil {
 		return err
 	}
-	if opts.Verbose {
-		fmt.Println("lowered version number to 9")
-	}
+	log.VLog("lowered version number to 9")
 
 	return nil
 }
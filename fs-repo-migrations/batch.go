@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/ipfs/fs-repo-migrations/tools/stump"
+	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// Outcomes reported for each repo of a batch.
+const (
+	outcomeMigrated     = "migrated"
+	outcomeWouldMigrate = "would migrate"
+	outcomeCurrent      = "already at version"
+	outcomeFailed       = "failed"
+)
+
+// repoList is a flag that can be given several times.
+type repoList []string
+
+func (l *repoList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *repoList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// repoResult is one row of the batch summary.
+type repoResult struct {
+	Repo    string `json:"repo"`
+	From    int    `json:"from"`
+	To      int    `json:"to"`
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// expandRepos resolves the repo paths and glob patterns given with -repo into
+// a list of distinct directories. A pattern that matches nothing is an error,
+// since it most likely is a typo.
+func expandRepos(patterns []string) ([]string, error) {
+	var repos []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repo pattern %q: %s", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no repo matches %q", pattern)
+		}
+		for _, m := range matches {
+			dir, err := filepath.Abs(m)
+			if err != nil {
+				return nil, err
+			}
+			if seen[dir] {
+				continue
+			}
+			seen[dir] = true
+			repos = append(repos, dir)
+		}
+	}
+	return repos, nil
+}
+
+// migrateRepoFunc migrates a single repo of a batch. It is migrateRepo, but
+// for tests.
+var migrateRepoFunc = migrateRepo
+
+// runBatch migrates each repo to targetVer, running up to parallel of them at
+// once. Every repo is migrated by a separate invocation of this program, with
+// args added to its command line, so that a failing migration cannot affect
+// the others. With dryRun set, args must include -dry-run. The results are in
+// the same order as repos.
+func runBatch(repos []string, targetVer, parallel int, dryRun bool, args []string) []repoResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]repoResult, len(repos))
+	sem := make(chan struct{}, parallel)
+	var outLk sync.Mutex
+	var wg sync.WaitGroup
+	for i, repo := range repos {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, repo string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = migrateRepoFunc(repo, targetVer, dryRun, args, &outLk)
+		}(i, repo)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func migrateRepo(repo string, targetVer int, dryRun bool, args []string, outLk *sync.Mutex) repoResult {
+	res := repoResult{Repo: repo, To: targetVer}
+
+	var err error
+	res.From, err = migrations.RepoVersion(repo)
+	if err != nil {
+		res.Outcome = outcomeFailed
+		res.Error = err.Error()
+		return res
+	}
+	if res.From == targetVer {
+		res.Outcome = outcomeCurrent
+		return res
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		res.Outcome = outcomeFailed
+		res.Error = err.Error()
+		return res
+	}
+	cmdArgs := append([]string{"-repo", repo, "-to", strconv.Itoa(targetVer), "-y"}, args...)
+	cmd := exec.Command(self, cmdArgs...)
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		res.Outcome = outcomeFailed
+		res.Error = err.Error()
+		return res
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		res.Outcome = outcomeFailed
+		res.Error = err.Error()
+		return res
+	}
+	if err = cmd.Start(); err != nil {
+		res.Outcome = outcomeFailed
+		res.Error = err.Error()
+		return res
+	}
+
+	// JSON events already carry the repo path. Text is prefixed with it.
+	prefix := repo + ": "
+	if stump.Format == stump.FormatJSON {
+		prefix = ""
+	}
+	var lastErr string
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		copyLines(os.Stdout, stdout, prefix, outLk, nil)
+	}()
+	go func() {
+		defer wg.Done()
+		copyLines(os.Stderr, stderr, prefix, outLk, &lastErr)
+	}()
+	wg.Wait()
+
+	if err = cmd.Wait(); err != nil {
+		res.Outcome = outcomeFailed
+		res.Error = err.Error()
+		if lastErr != "" {
+			res.Error = strings.TrimSpace(strings.TrimPrefix(lastErr, "ipfs migration: "))
+		}
+		// Report the version the repo was left at.
+		if ver, verr := migrations.RepoVersion(repo); verr == nil {
+			res.To = ver
+		}
+		return res
+	}
+
+	res.Outcome = outcomeMigrated
+	if dryRun {
+		res.Outcome = outcomeWouldMigrate
+	}
+	return res
+}
+
+// copyLines copies the lines read from r to w, prefixing each one. If last is
+// not nil it is set to the last non-empty line.
+func copyLines(w io.Writer, r io.Reader, prefix string, lk *sync.Mutex, last *string) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if last != nil && strings.TrimSpace(line) != "" {
+			*last = strings.TrimSpace(line)
+		}
+		lk.Lock()
+		fmt.Fprintf(w, "%s%s\n", prefix, line)
+		lk.Unlock()
+	}
+}
+
+// printSummary writes one row per repo with its versions and outcome to w, or
+// a single summary event in JSON format.
+func printSummary(w io.Writer, results []repoResult) {
+	if stump.Format == stump.FormatJSON {
+		stump.Emit(stump.Event{Type: stump.EventSummary, Data: results})
+		return
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPO\tFROM\tTO\tOUTCOME")
+	for _, r := range results {
+		outcome := r.Outcome
+		if r.Error != "" {
+			outcome += ": " + r.Error
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", r.Repo, r.From, r.To, outcome)
+	}
+	tw.Flush()
+}
+
+// batchErr returns an error if any repo of the batch failed.
+func batchErr(results []repoResult) error {
+	var failed int
+	for _, r := range results {
+		if r.Outcome == outcomeFailed {
+			failed++
+		}
+	}
+	if failed != 0 {
+		return fmt.Errorf("%d of %d repos failed to migrate", failed, len(results))
+	}
+	return nil
+}
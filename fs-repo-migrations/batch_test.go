@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/fs-repo-migrations/tools/stump"
+)
+
+// fakeExitEnv makes the test binary act as the per-repo migration run by
+// migrateRepo: it prints a line to stdout and one to stderr, and exits with
+// the status in the variable.
+const fakeExitEnv = "FS_REPO_MIGRATIONS_TEST_EXIT"
+
+func TestMain(m *testing.M) {
+	if code := os.Getenv(fakeExitEnv); code != "" {
+		fmt.Println("migrating", strings.Join(os.Args[1:], " "))
+		fmt.Fprintln(os.Stderr, "ipfs migration:  fake failure")
+		n, _ := strconv.Atoi(code)
+		os.Exit(n)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExpandRepos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "batch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for _, d := range []string{"a/repo", "b/repo", "c/other"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := filepath.Join(dir, "a/repo"), filepath.Join(dir, "b/repo")
+
+	cases := []struct {
+		name     string
+		patterns []string
+		want     []string
+		fail     bool
+	}{
+		{"path", []string{a}, []string{a}, false},
+		{"glob", []string{filepath.Join(dir, "*/repo")}, []string{a, b}, false},
+		{"duplicates", []string{b, filepath.Join(dir, "*/repo"), b}, []string{b, a}, false},
+		{"no match", []string{a, filepath.Join(dir, "*/missing")}, nil, true},
+		{"bad pattern", []string{filepath.Join(dir, "[")}, nil, true},
+	}
+	for _, c := range cases {
+		got, err := expandRepos(c.patterns)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%s: expanded to %q, want an error", c.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expanded to %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	// Relative paths are made absolute, so that duplicates are found.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	got, err := expandRepos([]string{"a/repo", a})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{a}) {
+		t.Fatalf("expanded to %q, want %q", got, []string{a})
+	}
+}
+
+func TestRunBatchParallel(t *testing.T) {
+	defer func(f func(string, int, bool, []string, *sync.Mutex) repoResult) { migrateRepoFunc = f }(migrateRepoFunc)
+
+	var lk sync.Mutex
+	var running, max int
+	migrateRepoFunc = func(repo string, targetVer int, dryRun bool, args []string, _ *sync.Mutex) repoResult {
+		lk.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		lk.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		lk.Lock()
+		running--
+		lk.Unlock()
+		outcome := outcomeMigrated
+		if dryRun {
+			outcome = outcomeWouldMigrate
+		}
+		return repoResult{Repo: repo, To: targetVer, Outcome: outcome, Error: strings.Join(args, " ")}
+	}
+
+	repos := []string{"r0", "r1", "r2", "r3", "r4", "r5", "r6", "r7"}
+	for _, c := range []struct{ parallel, want int }{{0, 1}, {1, 1}, {3, 3}, {20, len(repos)}} {
+		max = 0
+		results := runBatch(repos, 12, c.parallel, c.parallel == 3, []string{"-x"})
+		if max != c.want {
+			t.Errorf("-parallel %d: %d repos migrated at once, want %d", c.parallel, max, c.want)
+		}
+		for i, r := range results {
+			if r.Repo != repos[i] || r.To != 12 || r.Error != "-x" {
+				t.Errorf("-parallel %d: result %d is %+v", c.parallel, i, r)
+			}
+			if (r.Outcome == outcomeWouldMigrate) != (c.parallel == 3) {
+				t.Errorf("-parallel %d: dry run not passed on: %+v", c.parallel, r)
+			}
+		}
+	}
+}
+
+func TestMigrateRepo(t *testing.T) {
+	current := makeRepo(t, "12")
+	old := makeRepo(t, "11")
+	var lk sync.Mutex
+
+	cases := []struct {
+		name   string
+		repo   string
+		exit   string
+		dryRun bool
+		want   repoResult
+	}{
+		{"current", current, "0", false, repoResult{Repo: current, From: 12, To: 12, Outcome: outcomeCurrent}},
+		{"missing", filepath.Join(current, "missing"), "0", false, repoResult{Repo: filepath.Join(current, "missing"), To: 12, Outcome: outcomeFailed}},
+		{"migrated", old, "0", false, repoResult{Repo: old, From: 11, To: 12, Outcome: outcomeMigrated}},
+		{"dry run", old, "0", true, repoResult{Repo: old, From: 11, To: 12, Outcome: outcomeWouldMigrate}},
+		{"failed", old, "1", false, repoResult{Repo: old, From: 11, To: 11, Outcome: outcomeFailed, Error: "fake failure"}},
+	}
+	for _, c := range cases {
+		t.Setenv(fakeExitEnv, c.exit)
+		got := migrateRepo(c.repo, 12, c.dryRun, nil, &lk)
+		if c.name == "missing" {
+			// The error comes from the OS.
+			got.Error = ""
+		}
+		if got != c.want {
+			t.Errorf("%s: got %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestCopyLines(t *testing.T) {
+	var out bytes.Buffer
+	var last string
+	var lk sync.Mutex
+	copyLines(&out, strings.NewReader("one\n\ntwo  \n  \nthree"), "/repo: ", &lk, &last)
+	want := "/repo: one\n/repo: \n/repo: two  \n/repo:   \n/repo: three\n"
+	if out.String() != want {
+		t.Errorf("copied %q, want %q", out.String(), want)
+	}
+	if last != "three" {
+		t.Errorf("last line %q, want %q", last, "three")
+	}
+
+	out.Reset()
+	copyLines(&out, strings.NewReader("{}\n"), "", &lk, nil)
+	if out.String() != "{}\n" {
+		t.Errorf("copied %q without a prefix", out.String())
+	}
+}
+
+func TestPrintSummary(t *testing.T) {
+	results := []repoResult{
+		{Repo: "/srv/a", From: 11, To: 12, Outcome: outcomeMigrated},
+		{Repo: "/srv/long/b", From: 12, To: 12, Outcome: outcomeCurrent},
+		{Repo: "/srv/c", From: 10, To: 11, Outcome: outcomeFailed, Error: "no space left"},
+		{Repo: "/srv/d", From: 11, To: 12, Outcome: outcomeWouldMigrate},
+	}
+
+	var out bytes.Buffer
+	printSummary(&out, results)
+	want := `
+REPO         FROM  TO  OUTCOME
+/srv/a       11    12  migrated
+/srv/long/b  12    12  already at version
+/srv/c       10    11  failed: no space left
+/srv/d       11    12  would migrate
+`
+	if out.String() != want {
+		t.Errorf("summary:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if err := batchErr(results); err == nil || err.Error() != "1 of 4 repos failed to migrate" {
+		t.Errorf("batch error %v", err)
+	}
+	if err := batchErr(results[:2]); err != nil {
+		t.Errorf("batch error %v without failures", err)
+	}
+
+	logOut, format := stump.LogOut, stump.Format
+	defer func() { stump.LogOut, stump.Format = logOut, format }()
+	var events bytes.Buffer
+	stump.LogOut, stump.Format = &events, stump.FormatJSON
+	out.Reset()
+	printSummary(&out, results)
+	if out.Len() != 0 {
+		t.Errorf("table printed in JSON format: %q", out.String())
+	}
+	var e struct {
+		Type string       `json:"type"`
+		Data []repoResult `json:"data"`
+	}
+	if err := json.Unmarshal(events.Bytes(), &e); err != nil {
+		t.Fatalf("%s: %q", err, events.String())
+	}
+	if e.Type != stump.EventSummary || !reflect.DeepEqual(e.Data, results) {
+		t.Errorf("summary event %+v", e)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	mg0 "github.com/ipfs/fs-repo-migrations/fs-repo-0-to-1/migration"
+	mg1 "github.com/ipfs/fs-repo-migrations/fs-repo-1-to-2/migration"
+	mg10 "github.com/ipfs/fs-repo-migrations/fs-repo-10-to-11/migration"
+	mg11 "github.com/ipfs/fs-repo-migrations/fs-repo-11-to-12/migration"
+	mg12 "github.com/ipfs/fs-repo-migrations/fs-repo-12-to-13/migration"
+	mg13 "github.com/ipfs/fs-repo-migrations/fs-repo-13-to-14/migration"
+	mg14 "github.com/ipfs/fs-repo-migrations/fs-repo-14-to-15/migration"
+	mg15 "github.com/ipfs/fs-repo-migrations/fs-repo-15-to-16/migration"
+	mg2 "github.com/ipfs/fs-repo-migrations/fs-repo-2-to-3/migration"
+	mg3 "github.com/ipfs/fs-repo-migrations/fs-repo-3-to-4/migration"
+	mg4 "github.com/ipfs/fs-repo-migrations/fs-repo-4-to-5/migration"
+	mg5 "github.com/ipfs/fs-repo-migrations/fs-repo-5-to-6/migration"
+	mg6 "github.com/ipfs/fs-repo-migrations/fs-repo-6-to-7/migration"
+	mg7 "github.com/ipfs/fs-repo-migrations/fs-repo-7-to-8/migration"
+	mg8 "github.com/ipfs/fs-repo-migrations/fs-repo-8-to-9/migration"
+	mg9 "github.com/ipfs/fs-repo-migrations/fs-repo-9-to-10/migration"
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+	"github.com/ipfs/fs-repo-migrations/tools/stump"
+	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// builtinMigrations holds every migration compiled into this binary, keyed by
+// the "X-to-Y" string returned by its Versions method.
+var builtinMigrations = migrate.Registry{}
+
+func init() {
+	for _, m := range []migrate.Migration{
+		&mg0.Migration{},
+		&mg1.Migration{},
+		&mg2.Migration{},
+		&mg3.Migration{},
+		&mg4.Migration{},
+		&mg5.Migration{},
+		&mg6.Migration{},
+		&mg7.Migration{},
+		&mg8.Migration{},
+		&mg9.Migration{},
+		&mg10.Migration{},
+		&mg11.Migration{},
+		&mg12.Migration{},
+		&mg13.Migration{},
+		&mg14.Migration{},
+		&mg15.Migration{},
+	} {
+		if err := builtinMigrations.Register(m); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// migrationFlags are the names of the flags defined by the built-in
+// migrations.
+var migrationFlags = map[string]bool{}
+
+// registerMigrationFlags adds the flags of the built-in migrations that have
+// options of their own to the command line flags.
+func registerMigrationFlags() {
+	var names []string
+	for name := range builtinMigrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fl, ok := builtinMigrations[name].(migrate.Flagger)
+		if !ok {
+			continue
+		}
+		fs := flag.NewFlagSet(name, flag.PanicOnError)
+		fl.RegisterFlags(fs)
+		fs.VisitAll(func(f *flag.Flag) {
+			flag.Var(f.Value, f.Name, name+": "+f.Usage)
+			migrationFlags[f.Name] = true
+		})
+	}
+}
+
+// migrationFlagArgs returns the flags of the built-in migrations that were
+// set on the command line, as arguments.
+func migrationFlagArgs() []string {
+	var args []string
+	flag.Visit(func(f *flag.Flag) {
+		if migrationFlags[f.Name] {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+// commandMain runs the command of a built-in migration named by args[0], such
+// as "verify-log" of 11-to-12, with the flags in the rest of args. It returns
+// the exit status of the program, or false if no migration has the command.
+func commandMain(args []string) (int, bool) {
+	var names []string
+	for name := range builtinMigrations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := builtinMigrations[name]
+		cmd, ok := migrate.FindCommand(m, args[0])
+		if !ok {
+			continue
+		}
+		// An unset IPFS_PATH is left for the -repo flag to report.
+		ipfsDir, _ := migrations.IpfsDir("")
+		if err := migrate.RunCommand(m, cmd, args[1:], "repo", ipfsDir); err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// latestBuiltinMigration returns the highest repo version that the built-in
+// migrations can reach, following the chain up from version 0.
+func latestBuiltinMigration() int {
+	var latest int
+	for {
+		if _, ok := builtinMigrations[fmt.Sprintf("%d-to-%d", latest, latest+1)]; !ok {
+			return latest
+		}
+		latest++
+	}
+}
+
+// runBuiltinMigrations migrates the repo at ipfsDir from its current version
+// to targetVer by calling Apply, or Revert when going backward, on each of the
+// built-in migrations in turn. Nothing is downloaded or executed. With dryRun,
+// only the first migration is run, as a dry run: the ones after it start from
+// the version it would write, so they cannot be tried until it has run.
+func runBuiltinMigrations(ipfsDir string, targetVer int, allowDowngrade, skipSpaceCheck, dryRun bool) error {
+	ipfsDir, err := migrations.CheckIpfsDir(ipfsDir)
+	if err != nil {
+		return err
+	}
+	fromVer, err := migrations.RepoVersion(ipfsDir)
+	if err != nil {
+		return fmt.Errorf("could not get repo version: %s", err)
+	}
+	if fromVer == targetVer {
+		return nil
+	}
+	if fromVer > targetVer && !allowDowngrade {
+		return fmt.Errorf("downgrade not allowed from %d to %d", fromVer, targetVer)
+	}
+
+	// Plan the whole chain before touching the repo, so that a missing
+	// migration is reported up front instead of half way through.
+	plan, err := builtinMigrations.Plan(fromVer, targetVer, false)
+	if err != nil {
+		return err
+	}
+	revert := plan.Revert
+
+	steps := plan.Steps
+	if dryRun {
+		if revert {
+			return fmt.Errorf("-dry-run cannot be used when reverting")
+		}
+		if name := steps[0].Versions(); !migrate.SupportDryRun[name] {
+			return fmt.Errorf("migration %s does not support -dry-run", name)
+		}
+		steps = steps[:1]
+	}
+
+	for _, m := range steps {
+		opts := migrate.Options{
+			Flags: migrate.Flags{
+				Path:           ipfsDir,
+				Revert:         revert,
+				Verbose:        true,
+				LogFormat:      stump.Format,
+				SkipSpaceCheck: skipSpaceCheck,
+				DryRun:         dryRun,
+			},
+			Verbose: true,
+		}
+		switch {
+		case dryRun:
+			stump.Log("Dry run of migration %s ...", m.Versions())
+		case revert:
+			stump.Log("Reverting migration %s ...", m.Versions())
+		default:
+			stump.Log("Running migration %s ...", m.Versions())
+		}
+		if err = migrate.Execute(m, opts); err != nil {
+			return fmt.Errorf("migration %s failed: %s", m.Versions(), err)
+		}
+	}
+	if dryRun {
+		for _, m := range plan.Steps[1:] {
+			stump.Log("Migration %s would run next, and can only be tried once %s has run.", m.Versions(), steps[0].Versions())
+		}
+		stump.Log("Dry run done: the repo was not changed.")
+		return nil
+	}
+	stump.Log("Success: fs-repo migrated to version %d.", targetVer)
+
+	return nil
+}
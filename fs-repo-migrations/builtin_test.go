@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-ipfs/repo/fsrepo"
+	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+const (
+	testSpec     = `{"mounts":[{"child":{"path":"blocks","shardFunc":"/repo/flatfs/shard/v1/next-to-last/2","sync":true,"type":"flatfs"},"mountpoint":"/blocks","prefix":"flatfs.datastore","type":"measure"},{"child":{"compression":"none","path":"datastore","type":"levelds"},"mountpoint":"/","prefix":"leveldb.datastore","type":"measure"}],"type":"mount"}`
+	testDiskSpec = `{"mounts":[{"mountpoint":"/blocks","path":"blocks","shardFunc":"/repo/flatfs/shard/v1/next-to-last/2","type":"flatfs"},{"mountpoint":"/","path":"datastore","type":"levelds"}],"type":"mount"}`
+)
+
+// makeRepo writes a repo at version with the default datastores of go-ipfs.
+func makeRepo(t *testing.T, version string) string {
+	dir, err := ioutil.TempDir("", "builtin-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	config := `{"Identity":{"PeerID":"QmPeerID` + filepath.Base(dir) + `"},"Datastore":{"Spec":` + testSpec + `}}`
+	files := map[string]string{
+		"config":         config,
+		"datastore_spec": testDiskSpec,
+		"version":        version,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// TestBuiltinChain runs 10-to-11 and 11-to-12, which both open the datastore
+// with the go-ipfs plugins, in one process, twice over.
+func TestBuiltinChain(t *testing.T) {
+	repoVersion := fsrepo.RepoVersion
+	for i := 0; i < 2; i++ {
+		dir := makeRepo(t, "10")
+		if err := runBuiltinMigrations(dir, 12, false, true, false); err != nil {
+			t.Fatalf("chain %d: %s", i, err)
+		}
+		ver, err := migrations.RepoVersion(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ver != 12 {
+			t.Fatalf("chain %d: repo at version %d, want 12", i, ver)
+		}
+	}
+	if fsrepo.RepoVersion != repoVersion {
+		t.Fatalf("fsrepo.RepoVersion changed from %d to %d", repoVersion, fsrepo.RepoVersion)
+	}
+}
+
+// TestBuiltinChainRevert reverts a repo migrated to 12 back to 10.
+func TestBuiltinChainRevert(t *testing.T) {
+	dir := makeRepo(t, "10")
+	if err := runBuiltinMigrations(dir, 12, false, true, false); err != nil {
+		t.Fatal(err)
+	}
+	err := runBuiltinMigrations(dir, 10, false, true, false)
+	if err == nil || !strings.Contains(err.Error(), "downgrade not allowed") {
+		t.Fatalf("got error %v, want downgrade not allowed", err)
+	}
+	if err := runBuiltinMigrations(dir, 10, true, true, false); err != nil {
+		t.Fatal(err)
+	}
+	ver, err := migrations.RepoVersion(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver != 10 {
+		t.Fatalf("repo at version %d, want 10", ver)
+	}
+}
+
+// TestBuiltinDryRun checks that a dry run leaves the repo at its version, and
+// that it is refused when it cannot be honoured.
+func TestBuiltinDryRun(t *testing.T) {
+	dir := makeRepo(t, "11")
+	if err := runBuiltinMigrations(dir, 13, false, true, true); err != nil {
+		t.Fatal(err)
+	}
+	ver, err := migrations.RepoVersion(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ver != 11 {
+		t.Fatalf("dry run left the repo at version %d, want 11", ver)
+	}
+
+	// 10-to-11 has no dry run.
+	err = runBuiltinMigrations(makeRepo(t, "10"), 12, false, true, true)
+	if err == nil || !strings.Contains(err.Error(), "does not support -dry-run") {
+		t.Fatalf("got error %v, want 10-to-11 not supporting -dry-run", err)
+	}
+
+	err = runBuiltinMigrations(makeRepo(t, "12"), 11, true, true, true)
+	if err == nil || !strings.Contains(err.Error(), "reverting") {
+		t.Fatalf("got error %v, want -dry-run refused when reverting", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ipfs/fs-repo-migrations/tools/repocheck"
+	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// checkMain implements the "check" subcommand, which inspects a repo without
+// modifying it. It returns the exit status of the program: 1 if any error was
+// found, 0 otherwise.
+func checkMain(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s check [options]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Check a repo against its version: that the config parses, that")
+		fmt.Fprintln(fs.Output(), "datastore_spec matches the config, that keystore file names are encoded,")
+		fmt.Fprintln(fs.Output(), "and what migrations left behind. The repo is not modified.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	repo := fs.String("repo", "", "path of the repo to check (default $IPFS_PATH)")
+	jsonOut := fs.Bool("json", false, "print the result as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	ipfsDir, err := migrations.IpfsDir(*repo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	}
+	res, err := repocheck.Check(ipfsDir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(res)
+	} else {
+		fmt.Printf("Repo %s at version %d\n", res.Repo, res.Version)
+		for _, p := range res.Problems {
+			fmt.Println(p)
+		}
+		if len(res.Problems) == 0 {
+			fmt.Println("No problems found.")
+		}
+	}
+
+	if res.Errors() != 0 {
+		return 1
+	}
+	return 0
+}
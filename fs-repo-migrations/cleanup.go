@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ipfs/fs-repo-migrations/tools/mfsr"
+	"github.com/ipfs/fs-repo-migrations/tools/repocheck"
+	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// cleanupMain implements the "cleanup" subcommand, which lists the files
+// migrations left in a repo and removes the ones selected by age or by the
+// number of versions since. It returns the exit status of the program.
+func cleanupMain(args []string) int {
+	fs := flag.NewFlagSet("cleanup", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s cleanup [options]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "List the files migrations left in a repo, with their size and the")
+		fmt.Fprintln(fs.Output(), "migration that created them. With -older-than or -versions-behind, remove")
+		fmt.Fprintln(fs.Output(), "the ones that match, except those needed to finish a migration that was")
+		fmt.Fprintln(fs.Output(), "interrupted and, unless -keep-revert-to is given, those needed to revert.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	repo := fs.String("repo", "", "path of the repo to clean up (default $IPFS_PATH)")
+	olderThan := fs.String("older-than", "", "remove artifacts last modified at least this long ago, e.g. \"720h\" or \"30d\"")
+	versionsBehind := fs.Int("versions-behind", 0, "remove artifacts of migrations the repo has moved at least this many versions past")
+	keepRevertTo := fs.Int("keep-revert-to", 0, "keep only what is needed to revert the repo to this version, -1 for nothing (default: keep all that is needed to revert)")
+	dryRun := fs.Bool("dry-run", false, "only show what would be removed")
+	yes := fs.Bool("y", false, "answer yes to all prompts")
+	jsonOut := fs.Bool("json", false, "print the artifacts and decisions as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	policy := repocheck.Policy{
+		VersionsBehind: *versionsBehind,
+		KeepRevertTo:   *keepRevertTo,
+	}
+	if *olderThan != "" {
+		d, err := parseAge(*olderThan)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: invalid -older-than:", err)
+			return 1
+		}
+		policy.OlderThan = d
+	}
+	// Without a filter, only list the artifacts.
+	listOnly := policy.OlderThan == 0 && policy.VersionsBehind == 0
+	if *jsonOut && !listOnly && !*dryRun && !*yes {
+		fmt.Fprintln(os.Stderr, "ipfs migration: -json requires -y or -dry-run when removing artifacts")
+		return 1
+	}
+
+	ipfsDir, err := migrations.IpfsDir(*repo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	}
+	// Lock the repo before looking at it, so that no migration changes
+	// what was decided before it is removed.
+	if !listOnly && !*dryRun {
+		lk, err := repocheck.Lock(ipfsDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+			return 1
+		}
+		defer lk.Close()
+	}
+	vs, err := mfsr.RepoPath(ipfsDir).Version()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	}
+	version, err := strconv.Atoi(vs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipfs migration: repo version %q is not a number\n", vs)
+		return 1
+	}
+
+	artifacts, err := repocheck.Artifacts(ipfsDir, version)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	}
+	now := time.Now()
+	plan := repocheck.Plan(artifacts, version, policy, now)
+	var (
+		n     int
+		total int64
+	)
+	for _, d := range plan {
+		if d.Remove {
+			n++
+			total += d.Size
+		}
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if listOnly {
+			enc.Encode(artifacts)
+		} else {
+			enc.Encode(plan)
+		}
+	} else {
+		fmt.Printf("Repo %s at version %d\n", ipfsDir, version)
+		if len(plan) == 0 {
+			fmt.Println("No migration artifacts found.")
+			return 0
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		if listOnly {
+			fmt.Fprintln(tw, "PATH\tSIZE\tMIGRATION\tSTATE\tAGE")
+		} else {
+			fmt.Fprintln(tw, "PATH\tSIZE\tMIGRATION\tSTATE\tAGE\tACTION")
+		}
+		for _, d := range plan {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s", d.Path, formatSize(d.Size), d.Migration, d.State, formatAge(now.Sub(d.ModTime)))
+			if !listOnly {
+				action := "keep (" + d.Reason + ")"
+				if d.Remove {
+					action = "remove"
+				}
+				fmt.Fprintf(tw, "\t%s", action)
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+	}
+
+	if listOnly || n == 0 || *dryRun {
+		return 0
+	}
+
+	prompt := fmt.Sprintf("Remove %d artifacts (%s) from %s? [y/n]", n, formatSize(total), ipfsDir)
+	if !(*yes || yesNoPrompt(prompt)) {
+		return 1
+	}
+	freed, err := repocheck.Cleanup(ipfsDir, plan)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: cleanup failed: ", err)
+		return 1
+	}
+	if !*jsonOut {
+		fmt.Printf("Removed %d artifacts, freed %s.\n", n, formatSize(freed))
+	}
+	return 0
+}
+
+// parseAge parses a duration, which may also be given in days, e.g. "30d".
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func formatAge(d time.Duration) string {
+	switch {
+	case d >= 48*time.Hour:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	case d >= time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	}
+}
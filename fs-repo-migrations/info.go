@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// migrationDetails is what the "info" subcommand prints about a migration.
+type migrationDetails struct {
+	Migration  string   `json:"migration"`
+	Summary    string   `json:"summary,omitempty"`
+	Areas      []string `json:"areas"`
+	Reversible bool     `json:"reversible"`
+	NoRevert   bool     `json:"no_revert"`
+	// Repo, RepoVersion and Cost are only set when the repo exists.
+	Repo        string        `json:"repo,omitempty"`
+	RepoVersion int           `json:"repo_version,omitempty"`
+	Cost        *migrate.Cost `json:"cost,omitempty"`
+}
+
+// describeMigration returns the details of the built-in migration name, but
+// not its cost, and its Describer, which is nil if it has none.
+func describeMigration(name string) (migrationDetails, migrate.Describer, error) {
+	m, ok := builtinMigrations[name]
+	if !ok {
+		return migrationDetails{}, nil, fmt.Errorf("no built-in migration %q", name)
+	}
+
+	d := migrationDetails{
+		Migration:  name,
+		Areas:      []string{},
+		Reversible: m.Reversible(),
+		NoRevert:   migrate.SupportNoRevert[name],
+	}
+	desc, ok := m.(migrate.Describer)
+	if !ok {
+		return d, nil, nil
+	}
+	d.Summary = desc.Describe().Summary
+	if areas := desc.Describe().Areas; areas != nil {
+		d.Areas = areas
+	}
+	return d, desc, nil
+}
+
+// infoMain implements the "info" subcommand, which describes one migration
+// and estimates its cost on a repo. It returns the exit status of the
+// program.
+func infoMain(args []string) int {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s info [options] N-to-M\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Describe a built-in migration: what it does, the parts of the repo it")
+		fmt.Fprintln(fs.Output(), "changes, and, if the repo exists, how much it would have to process there.")
+		fmt.Fprintln(fs.Output(), "The repo is not modified.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	repo := fs.String("repo", "", "path of the repo to estimate the cost on (default $IPFS_PATH)")
+	jsonOut := fs.Bool("json", false, "print the description as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	name := fs.Arg(0)
+	d, desc, err := describeMigration(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ipfs migration: %s, see \"%s list\"\n", err, os.Args[0])
+		return 1
+	}
+	ok := desc != nil
+
+	ipfsDir, err := migrations.IpfsDir(*repo)
+	if err == nil {
+		_, err = os.Stat(ipfsDir)
+	}
+	switch {
+	case os.IsNotExist(err) && *repo == "":
+		// No repo to estimate on, which is fine unless one was asked for.
+	case err != nil:
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	case ok:
+		cost, err := desc.Estimate(ipfsDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+			return 1
+		}
+		d.Repo, d.Cost = ipfsDir, &cost
+		if ver, err := migrations.RepoVersion(ipfsDir); err == nil {
+			d.RepoVersion = ver
+		}
+	}
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(d)
+		return 0
+	}
+
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	fmt.Printf("Migration:   %s\n", d.Migration)
+	if d.Summary != "" {
+		fmt.Printf("Summary:     %s\n", d.Summary)
+	}
+	areas := strings.Join(d.Areas, ", ")
+	if areas == "" {
+		areas = "-"
+	}
+	if !ok {
+		areas = "?"
+	}
+	fmt.Printf("Changes:     %s\n", areas)
+	fmt.Printf("Reversible:  %s\n", yesNo[d.Reversible])
+	fmt.Printf("No-revert:   %s\n", yesNo[d.NoRevert])
+	if d.Cost == nil {
+		return 0
+	}
+
+	fmt.Printf("Repo:        %s (version %d)\n", d.Repo, d.RepoVersion)
+	if from, _ := migrate.SplitVersion(name); d.RepoVersion != from {
+		fmt.Printf("             the migration applies to version %d repos, the estimate may not mean much\n", from)
+	}
+	if d.Cost.Items != 0 {
+		fmt.Printf("Estimate:    %d %s, %s\n", d.Cost.Items, d.Cost.Unit, formatSize(d.Cost.Bytes))
+	} else {
+		fmt.Printf("Estimate:    %s\n", formatSize(d.Cost.Bytes))
+	}
+	if d.Cost.Note != "" {
+		fmt.Printf("             %s\n", d.Cost.Note)
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDescribeMigration(t *testing.T) {
+	for _, want := range builtinList {
+		d, desc, err := describeMigration(want.migration)
+		if err != nil {
+			t.Errorf("%s: %s", want.migration, err)
+			continue
+		}
+		if desc == nil || d.Summary == "" {
+			t.Errorf("%s: no description", want.migration)
+		}
+		if d.Migration != want.migration || d.Reversible != want.reversible || d.NoRevert != want.noRevert || !reflect.DeepEqual(d.Areas, want.touches) {
+			t.Errorf("%s: described as %+v", want.migration, d)
+		}
+		if d.Repo != "" || d.Cost != nil {
+			t.Errorf("%s: cost without a repo: %+v", want.migration, d)
+		}
+	}
+
+	for _, name := range []string{"", "16-to-17", "12-to-14", "13-to-12", "12to13", "012-to-013", "fs-repo-12-to-13", "12-to-13 "} {
+		if d, _, err := describeMigration(name); err == nil {
+			t.Errorf("%q: described as %+v", name, d)
+		}
+	}
+}
+
+func TestDescribeEstimate(t *testing.T) {
+	repo := makeRepo(t, "12")
+	_, desc, err := describeMigration("12-to-13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cost, err := desc.Estimate(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost.Items != 1 || cost.Unit != "files" || cost.Bytes == 0 {
+		t.Errorf("config migration estimated at %+v", cost)
+	}
+}
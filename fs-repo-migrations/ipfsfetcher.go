@@ -2,16 +2,24 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	api "github.com/ipfs/go-ipfs-api"
 	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
 )
 
 const (
@@ -24,17 +32,21 @@ const (
 
 type ipfsFetcher struct {
 	distPath string
+	repoPath string
 	limit    int64
 }
 
-// newIpfsFetcher creates a new IpfsFetcher
+// newIpfsFetcher creates a new IpfsFetcher that fetches through the daemon of
+// the repo at repoPath.
 //
 // Specifying "" for distPath sets the default IPNS path.
+// Specifying "" for repoPath uses the repo at IPFS_PATH.
 // Specifying 0 for fetchLimit sets the default, -1 means no limit.
-func newIpfsFetcher(distPath string, fetchLimit int64) *ipfsFetcher {
+func newIpfsFetcher(distPath, repoPath string, fetchLimit int64) *ipfsFetcher {
 	f := &ipfsFetcher{
 		limit:    defaultFetchLimit,
 		distPath: migrations.LatestIpfsDist,
+		repoPath: repoPath,
 	}
 
 	if distPath != "" {
@@ -58,7 +70,7 @@ func newIpfsFetcher(distPath string, fetchLimit int64) *ipfsFetcher {
 // site configured for this HttpFetcher.  Returns io.ReadCloser on success,
 // which caller must close.
 func (f *ipfsFetcher) Fetch(ctx context.Context, filePath string) (io.ReadCloser, error) {
-	sh, _, err := apiShell("")
+	sh, _, err := apiShell(f.repoPath)
 	if err != nil {
 		return nil, err
 	}
@@ -77,14 +89,51 @@ func (f *ipfsFetcher) Fetch(ctx context.Context, filePath string) (io.ReadCloser
 	return resp.Output, nil
 }
 
+// Close does nothing, as the fetcher keeps no connection to the daemon
+// between fetches.
+func (f *ipfsFetcher) Close() error {
+	return nil
+}
+
 // apiShell creates a new ipfs api shell and checks that it is up.  If the shell
 // is available, then the shell and ipfs version are returned.
 func apiShell(ipfsDir string) (*api.Shell, string, error) {
-	apiEp, err := apiEndpoint("")
+	ipfsDir, err := migrations.CheckIpfsDir(ipfsDir)
+	if err != nil {
+		return nil, "", err
+	}
+	addr, err := apiEndpoint(ipfsDir)
 	if err != nil {
 		return nil, "", err
 	}
-	sh := api.NewShell(apiEp)
+	auth, err := apiAuthorization(ipfsDir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	transport := &http.Transport{
+		Proxy:             http.ProxyFromEnvironment,
+		DisableKeepAlives: true,
+	}
+	url := addr.address
+	if addr.network == "unix" {
+		// The host in the URL is ignored, every request goes to the socket.
+		var d net.Dialer
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", addr.address)
+		}
+		url = "localhost"
+	}
+	if addr.https {
+		url = "https://" + url
+	}
+	var rt http.RoundTripper = transport
+	if auth != "" {
+		rt = authTransport{rt, auth}
+	}
+
+	sh := api.NewShellWithClient(url, &http.Client{Transport: rt})
 	sh.SetTimeout(shellUpTimeout)
 	ver, _, err := sh.Version()
 	if err != nil {
@@ -94,25 +143,127 @@ func apiShell(ipfsDir string) (*api.Shell, string, error) {
 	return sh, ver, nil
 }
 
-// apiEndpoint reads the api file from the local ipfs install directory and
-// returns the address:port read from the file.  If the ipfs directory is not
-// specified then the default location is used.
-func apiEndpoint(ipfsDir string) (string, error) {
-	ipfsDir, err := migrations.CheckIpfsDir(ipfsDir)
+// apiAddr is where the API of the local daemon listens.
+type apiAddr struct {
+	// network is "tcp" or "unix".
+	network string
+	// address is host:port, or the path of the socket.
+	address string
+	https   bool
+}
+
+// apiEndpoint reads the api file of the ipfs repo at ipfsDir, and returns
+// the address it holds.
+func apiEndpoint(ipfsDir string) (*apiAddr, error) {
+	apiData, err := ioutil.ReadFile(filepath.Join(ipfsDir, apiFile))
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return parseAPIAddr(strings.TrimSpace(string(apiData)))
+}
+
+// parseAPIAddr parses the multiaddr of an API listener, such as
+// "/ip4/127.0.0.1/tcp/5001", "/dns4/localhost/tcp/5001/https" or
+// "/unix/run/ipfs.sock".
+func parseAPIAddr(s string) (*apiAddr, error) {
+	maddr, err := ma.NewMultiaddr(s)
+	if err != nil {
+		return nil, fmt.Errorf("incorrectly formatted api multiaddr %q: %s", s, err)
+	}
+	network, address, err := manet.DialArgs(maddr)
+	if err != nil {
+		return nil, fmt.Errorf("incorrectly formatted api multiaddr %q: %s", s, err)
+	}
+
+	addr := &apiAddr{address: address}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		addr.network = "tcp"
+	case "unix":
+		addr.network = "unix"
+	default:
+		return nil, fmt.Errorf("api multiaddr %q is not a tcp or unix address", s)
 	}
+	ma.ForEach(maddr, func(c ma.Component) bool {
+		if c.Protocol().Code == ma.P_HTTPS {
+			addr.https = true
+		}
+		return true
+	})
+	return addr, nil
+}
 
-	apiData, err := ioutil.ReadFile(path.Join(ipfsDir, apiFile))
+// apiAuthorization returns the Authorization header to send to the API of
+// the daemon of the repo at ipfsDir, from the first entry of
+// API.Authorizations in its config that allows the commands run by
+// ipfsFetcher, or "" if there is none.
+func apiAuthorization(ipfsDir string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(ipfsDir, "config"))
 	if err != nil {
 		return "", err
 	}
+	var cfg struct {
+		API struct {
+			Authorizations map[string]struct {
+				AuthSecret   string
+				AllowedPaths []string
+			}
+		}
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return "", fmt.Errorf("cannot read API.Authorizations from config: %s", err)
+	}
 
-	val := strings.TrimSpace(string(apiData))
-	parts := strings.Split(val, "/")
-	if len(parts) != 5 {
-		return "", fmt.Errorf("incorrectly formatted api string: %q", val)
+	names := make([]string, 0, len(cfg.API.Authorizations))
+	for name := range cfg.API.Authorizations {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		a := cfg.API.Authorizations[name]
+		if !apiPathAllowed(a.AllowedPaths, "/api/v0/cat") || !apiPathAllowed(a.AllowedPaths, "/api/v0/version") {
+			continue
+		}
+		typ, secret := a.AuthSecret, ""
+		if i := strings.Index(typ, ":"); i >= 0 {
+			typ, secret = typ[:i], typ[i+1:]
+		}
+		switch typ {
+		case "bearer":
+			return "Bearer " + secret, nil
+		case "basic":
+			// The secret is either user:password or already encoded.
+			if strings.Contains(secret, ":") {
+				secret = base64.StdEncoding.EncodeToString([]byte(secret))
+			}
+			return "Basic " + secret, nil
+		default:
+			return "", fmt.Errorf("API.Authorizations.%s: unsupported AuthSecret type %q", name, typ)
+		}
+	}
+	return "", nil
+}
+
+// apiPathAllowed reports whether p is below one of the allowed paths.
+func apiPathAllowed(allowed []string, p string) bool {
+	for _, a := range allowed {
+		a = strings.TrimSuffix(a, "/")
+		if p == a || strings.HasPrefix(p, a+"/") || a == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// authTransport adds an Authorization header to every request.
+type authTransport struct {
+	rt   http.RoundTripper
+	auth string
+}
 
-	return parts[2] + ":" + parts[4], nil
+func (t authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", t.auth)
+	return t.rt.RoundTrip(req)
 }
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAPIAddr(t *testing.T) {
+	cases := []struct {
+		addr    string
+		network string
+		address string
+		https   bool
+	}{
+		{"/ip4/127.0.0.1/tcp/5001", "tcp", "127.0.0.1:5001", false},
+		{"/ip6/::1/tcp/5001", "tcp", "[::1]:5001", false},
+		{"/ip6zone/eth0/ip6/fe80::1/tcp/5001", "tcp", "[fe80::1%eth0]:5001", false},
+		{"/dns4/localhost/tcp/5001/http", "tcp", "localhost:5001", false},
+		{"/dns/ipfs.example.com/tcp/443/https", "tcp", "ipfs.example.com:443", true},
+		{"/unix/run/ipfs.sock", "unix", "/run/ipfs.sock", false},
+	}
+	for _, c := range cases {
+		addr, err := parseAPIAddr(c.addr)
+		if err != nil {
+			t.Errorf("%s: %s", c.addr, err)
+			continue
+		}
+		if addr.network != c.network || addr.address != c.address || addr.https != c.https {
+			t.Errorf("%s: parsed to %+v", c.addr, *addr)
+		}
+	}
+
+	for _, s := range []string{
+		"",
+		"127.0.0.1:5001",
+		"/ip4/127.0.0.1",
+		"/ip4/127.0.0.1/udp/5001",
+		"/ip4/127.0.0.1/tcp/70000",
+		"/ip4/127.0.0.256/tcp/5001",
+		"/ip4/127.0.0.1/tcp",
+		"/foo/127.0.0.1/tcp/5001",
+	} {
+		if addr, err := parseAPIAddr(s); err == nil {
+			t.Errorf("expected an error parsing %q, got %+v", s, *addr)
+		}
+	}
+}
+
+// writeRepo writes the files of a repo to a new directory and returns its
+// path.
+func writeRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "ipfsfetcher-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestAPIEndpoint(t *testing.T) {
+	dir := writeRepo(t, map[string]string{"api": "/ip4/127.0.0.1/tcp/5002\n"})
+	addr, err := apiEndpoint(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.address != "127.0.0.1:5002" {
+		t.Fatalf("read address %s from the api file", addr.address)
+	}
+
+	if _, err := apiEndpoint(writeRepo(t, nil)); !os.IsNotExist(err) {
+		t.Fatalf("expected an error for a repo without an api file, got %v", err)
+	}
+}
+
+func TestAPIAuthorization(t *testing.T) {
+	cases := []struct {
+		name   string
+		config string
+		want   string
+		fail   bool
+	}{{
+		name:   "none",
+		config: `{"API":{"HTTPHeaders":{}}}`,
+	}, {
+		name:   "bearer",
+		config: `{"API":{"Authorizations":{"a":{"AuthSecret":"bearer:token","AllowedPaths":["/api/v0"]}}}}`,
+		want:   "Bearer token",
+	}, {
+		name:   "basic",
+		config: `{"API":{"Authorizations":{"a":{"AuthSecret":"basic:user:pass","AllowedPaths":["/api/v0/"]}}}}`,
+		want:   "Basic dXNlcjpwYXNz",
+	}, {
+		name:   "basic encoded",
+		config: `{"API":{"Authorizations":{"a":{"AuthSecret":"basic:dXNlcjpwYXNz","AllowedPaths":["/"]}}}}`,
+		want:   "Basic dXNlcjpwYXNz",
+	}, {
+		name: "first allowed",
+		config: `{"API":{"Authorizations":{
+			"a":{"AuthSecret":"bearer:version-only","AllowedPaths":["/api/v0/version"]},
+			"b":{"AuthSecret":"bearer:cat","AllowedPaths":["/api/v0/cat","/api/v0/version"]},
+			"c":{"AuthSecret":"bearer:all","AllowedPaths":["/api/v0"]}}}}`,
+		want: "Bearer cat",
+	}, {
+		name:   "not allowed",
+		config: `{"API":{"Authorizations":{"a":{"AuthSecret":"bearer:token","AllowedPaths":["/api/v0/cat"]}}}}`,
+	}, {
+		name:   "unsupported type",
+		config: `{"API":{"Authorizations":{"a":{"AuthSecret":"digest:token","AllowedPaths":["/api/v0"]}}}}`,
+		fail:   true,
+	}, {
+		name:   "bad config",
+		config: `{"API":{"Authorizations":[]}}`,
+		fail:   true,
+	}}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := apiAuthorization(writeRepo(t, map[string]string{"config": c.config}))
+			if c.fail {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Fatalf("got %q, expected %q", got, c.want)
+			}
+		})
+	}
+}
+
+// TestIpfsFetcherRepo checks that the fetcher uses the api file and the
+// API.Authorizations of the repo it is given, not of IPFS_PATH.
+func TestIpfsFetcherRepo(t *testing.T) {
+	const auth = "Bearer token"
+	var cats []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != auth {
+			t.Errorf("%s sent with Authorization %q", r.URL.Path, got)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v0/version":
+			w.Write([]byte(`{"Version":"0.8.0","Commit":""}`))
+		case "/api/v0/cat":
+			arg := r.URL.Query().Get("arg")
+			cats = append(cats, arg)
+			w.Write([]byte("v1.0.0\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := writeRepo(t, map[string]string{
+		"api":    "/ip4/127.0.0.1/tcp/" + u.Port(),
+		"config": `{"API":{"Authorizations":{"a":{"AuthSecret":"bearer:token","AllowedPaths":["/api/v0"]}}}}`,
+	})
+	// The daemon of IPFS_PATH is not up.
+	t.Setenv("IPFS_PATH", writeRepo(t, map[string]string{
+		"api":    "/ip4/127.0.0.1/tcp/1",
+		"config": `{}`,
+	}))
+
+	f := newIpfsFetcher("/ipfs/QmDist", repo, 0)
+	rc, err := f.Fetch(context.Background(), "fs-repo-11-to-12/versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "v1.0.0\n" {
+		t.Fatalf("fetched %q", data)
+	}
+	if len(cats) != 1 || cats[0] != "/ipfs/QmDist/fs-repo-11-to-12/versions" {
+		t.Fatalf("cat called with %q", cats)
+	}
+
+	if _, err := newIpfsFetcher("/ipfs/QmDist", "", 0).Fetch(context.Background(), "fs-repo-11-to-12/versions"); err == nil {
+		t.Fatal("expected an error fetching through the daemon of IPFS_PATH")
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ipfs/fs-repo-migrations/tools/stump"
+)
+
+// kuboRelease is a Kubo (formerly go-ipfs) version as major, minor, patch.
+type kuboRelease [3]int
+
+// kuboRepoVersions maps Kubo releases to the repo version they use: each
+// entry applies from its first release up to the next entry.
+var kuboRepoVersions = []struct {
+	first kuboRelease
+	repo  int
+}{
+	{kuboRelease{0, 0, 0}, 1},
+	{kuboRelease{0, 3, 0}, 2},
+	{kuboRelease{0, 4, 0}, 3},
+	{kuboRelease{0, 4, 3}, 4},
+	{kuboRelease{0, 4, 6}, 5},
+	{kuboRelease{0, 4, 11}, 6},
+	{kuboRelease{0, 4, 16}, 7},
+	// Repo version 8 was only used by development builds of 0.5.0.
+	{kuboRelease{0, 5, 0}, 9},
+	{kuboRelease{0, 6, 0}, 10},
+	{kuboRelease{0, 8, 0}, 11},
+	{kuboRelease{0, 12, 0}, 12},
+	{kuboRelease{0, 18, 0}, 13},
+	{kuboRelease{0, 21, 0}, 14},
+	{kuboRelease{0, 23, 0}, 15},
+	{kuboRelease{0, 30, 0}, 16},
+	{kuboRelease{0, 37, 0}, 17},
+	{kuboRelease{0, 38, 0}, 18},
+}
+
+// kuboTargetPrefixes are the prefixes of a -to value naming a release.
+var kuboTargetPrefixes = []string{"kubo@", "go-ipfs@"}
+
+// parseKuboTarget returns the repo version used by the release named by a
+// -to value such as "kubo@0.23.0". ok is false if s does not name a release.
+func parseKuboTarget(s string) (repo int, ok bool, err error) {
+	for _, p := range kuboTargetPrefixes {
+		if strings.HasPrefix(s, p) {
+			repo, err = kuboRepoVersion(strings.TrimPrefix(s, p))
+			return repo, true, err
+		}
+	}
+	return 0, false, nil
+}
+
+// kuboRepoVersion returns the repo version used by a Kubo release, given as
+// "0.23.0", "v0.23.0-rc1" or "0.23".
+func kuboRepoVersion(version string) (int, error) {
+	rel, err := parseKuboRelease(version)
+	if err != nil {
+		return 0, err
+	}
+	repo := 0
+	for _, e := range kuboRepoVersions {
+		if !rel.less(e.first) {
+			repo = e.repo
+		}
+	}
+	return repo, nil
+}
+
+func parseKuboRelease(version string) (kuboRelease, error) {
+	s := strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	var rel kuboRelease
+	if len(parts) < 2 || len(parts) > 3 {
+		return rel, fmt.Errorf("invalid Kubo version %q", version)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return rel, fmt.Errorf("invalid Kubo version %q", version)
+		}
+		rel[i] = n
+	}
+	return rel, nil
+}
+
+func (r kuboRelease) less(o kuboRelease) bool {
+	for i := range r {
+		if r[i] != o[i] {
+			return r[i] < o[i]
+		}
+	}
+	return false
+}
+
+// warnInstalledKubo warns if the ipfs binary on PATH uses another repo version
+// than target, since it would refuse to open the migrated repo or migrate it
+// again.
+func warnInstalledKubo(target int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "ipfs", "version", "--number").Output()
+	if err != nil {
+		// Not installed, or too old to know --number.
+		return
+	}
+	version := strings.TrimSpace(string(out))
+	repo, err := kuboRepoVersion(version)
+	if err != nil || repo == target {
+		return
+	}
+	stump.Warn("the ipfs binary on PATH is Kubo %s, which uses repo version %d, not %d", version, repo, target)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestParseKuboTarget(t *testing.T) {
+	cases := []struct {
+		s       string
+		repo    int
+		release bool
+		fail    bool
+	}{
+		{"kubo@0.23.0", 15, true, false},
+		{"kubo@v0.23.0", 15, true, false},
+		{"kubo@0.23", 15, true, false},
+		{"kubo@0.22.0", 14, true, false},
+		{"kubo@0.30.0-rc1", 16, true, false},
+		{"kubo@0.29.0+dirty", 15, true, false},
+		{"kubo@0.36.0", 16, true, false},
+		{"kubo@0.37.0", 17, true, false},
+		{"kubo@0.40.1", 18, true, false},
+		{"kubo@1.0.0", 18, true, false},
+		{"go-ipfs@0.4.23", 7, true, false},
+		{"go-ipfs@0.5.0", 9, true, false},
+		{"go-ipfs@0.3.11", 2, true, false},
+		{"go-ipfs@0.2.3", 1, true, false},
+		{"kubo@", 0, true, true},
+		{"kubo@latest", 0, true, true},
+		{"kubo@0", 0, true, true},
+		{"kubo@0.23.0.1", 0, true, true},
+		{"kubo@0.-1.0", 0, true, true},
+		{"kubo@0.x.0", 0, true, true},
+		{"15", 0, false, false},
+		{"latest", 0, false, false},
+		{"ipfs@0.23.0", 0, false, false},
+		{"Kubo@0.23.0", 0, false, false},
+	}
+	for _, c := range cases {
+		repo, release, err := parseKuboTarget(c.s)
+		if release != c.release {
+			t.Errorf("%q: parsed as a release %t, expected %t", c.s, release, c.release)
+			continue
+		}
+		if c.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error, got repo version %d", c.s, repo)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c.s, err)
+			continue
+		}
+		if repo != c.repo {
+			t.Errorf("%q: repo version %d, expected %d", c.s, repo, c.repo)
+		}
+	}
+}
+
+func TestKuboRepoVersionsOrder(t *testing.T) {
+	for i := 1; i < len(kuboRepoVersions); i++ {
+		prev, e := kuboRepoVersions[i-1], kuboRepoVersions[i]
+		if !prev.first.less(e.first) || prev.repo >= e.repo {
+			t.Errorf("entry %v for repo version %d does not follow %v for %d", e.first, e.repo, prev.first, prev.repo)
+		}
+	}
+}
+
+// readmeRow matches a row of the table of repo versions in the README, such
+// as "|  15 | 0.23.0 - 0.29.0  |".
+var readmeRow = regexp.MustCompile(`^\|\s*(\d+)\s*\|\s*(\d+\.\d+\.\d+)\.?(?:\s*-\s*(\d+\.\d+\.\d+|current)\.?)?\s*\|`)
+
+// TestKuboRepoVersionsReadme checks kuboRepoVersions against the table in the
+// README, for the first and last release of each repo version.
+func TestKuboRepoVersionsReadme(t *testing.T) {
+	f, err := os.Open("../README.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	rows := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		m := readmeRow.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		rows++
+		repo, _ := strconv.Atoi(m[1])
+		if repo == 8 {
+			// Only development builds of 0.5.0 used it, and the
+			// releases of its row use 9.
+			continue
+		}
+		for _, v := range []string{m[2], m[3]} {
+			if v == "" || v == "current" {
+				continue
+			}
+			got, err := kuboRepoVersion(v)
+			if err != nil {
+				t.Errorf("%s: %s", v, err)
+				continue
+			}
+			// The rows of 9 and 10 both list 0.6.0, which uses 10.
+			if got != repo && !(v == "0.6.0" && repo == 9 && got == 10) {
+				t.Errorf("Kubo %s uses repo version %d, the README says %d", v, got, repo)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if rows < len(kuboRepoVersions) {
+		t.Fatalf("found %d rows in the README table, expected at least %d", rows, len(kuboRepoVersions))
+	}
+}
+
+// TestLatestMigrationOffline checks that without -fetch the latest migration is
+// the latest built-in one, so that no dist version is looked up.
+func TestLatestMigrationOffline(t *testing.T) {
+	latest, err := latestMigration(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest != latestBuiltinMigration() {
+		t.Fatalf("latest migration %d, the latest built-in one is %d", latest, latestBuiltinMigration())
+	}
+}
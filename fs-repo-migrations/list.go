@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	migrate "github.com/ipfs/fs-repo-migrations/tools/go-migrate"
+	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// Where a migration is run from.
+const (
+	sourceBuiltin = "builtin"
+	sourceFetched = "fetched"
+)
+
+// migrationInfo describes a migration for the "list" subcommand. Fields that
+// are only known for built-in migrations are nil for the others.
+type migrationInfo struct {
+	Migration   string   `json:"migration"`
+	Dist        string   `json:"dist"`
+	DistVersion string   `json:"dist_version,omitempty"`
+	Builtin     bool     `json:"builtin"`
+	Source      string   `json:"source"`
+	Reversible  *bool    `json:"reversible"`
+	NoRevert    *bool    `json:"no_revert"`
+	Touches     []string `json:"touches"`
+}
+
+// listMain implements the "list" subcommand, which prints the migrations
+// this tool knows of. It returns the exit status of the program.
+func listMain(args []string) int {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s list [options]\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "List the migrations, whether they can be reverted and support -no-revert,")
+		fmt.Fprintln(fs.Output(), "and the parts of the repo they change (\"info\" tells more). With -fetch,")
+		fmt.Fprintln(fs.Output(), "also look up the latest version of each on the distribution site, and")
+		fmt.Fprintln(fs.Output(), "list the migrations that are only available there.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	fetch := fs.Bool("fetch", false, "look up the migrations on the distribution site")
+	distPath := fs.String("distpath", "", "specify the distributions build to use, or a local mirror (requires -fetch)")
+	jsonOut := fs.Bool("json", false, "print the list as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+	if *distPath != "" && !*fetch {
+		fmt.Fprintln(os.Stderr, "-distpath is only used together with -fetch")
+		return 1
+	}
+
+	var fetcher migrations.Fetcher
+	if *fetch {
+		var err error
+		// Only versions files are fetched, so no key is needed.
+		if fetcher, err = createFetcher(*distPath, "", nil, false); err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+			return 1
+		}
+	}
+
+	list := listMigrations(fetcher)
+
+	if *jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(list)
+		return 0
+	}
+
+	yesNo := func(b *bool) string {
+		switch {
+		case b == nil:
+			return "?"
+		case *b:
+			return "yes"
+		}
+		return "no"
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MIGRATION\tSOURCE\tDIST VERSION\tREVERSIBLE\tNO-REVERT\tTOUCHES")
+	for _, m := range list {
+		distVer, touches := m.DistVersion, strings.Join(m.Touches, ",")
+		if distVer == "" {
+			distVer = "-"
+		}
+		switch {
+		case m.Touches == nil && !m.Builtin:
+			touches = "?"
+		case touches == "":
+			touches = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", m.Migration, m.Source, distVer, yesNo(m.Reversible), yesNo(m.NoRevert), touches)
+	}
+	tw.Flush()
+	return 0
+}
+
+// listMigrations returns the built-in migrations, in order. When fetcher is
+// not nil, their latest version on the distribution site is filled in, and
+// the migrations found there past the built-in ones are added.
+func listMigrations(fetcher migrations.Fetcher) []migrationInfo {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var list []migrationInfo
+	for v := 0; ; v++ {
+		name := fmt.Sprintf("%d-to-%d", v, v+1)
+		m, builtin := builtinMigrations[name]
+		info := migrationInfo{
+			Migration: name,
+			Dist:      "fs-repo-" + name,
+			Builtin:   builtin,
+			Source:    sourceBuiltin,
+		}
+		if builtin {
+			reversible, noRevert := m.Reversible(), migrate.SupportNoRevert[name]
+			info.Reversible, info.NoRevert = &reversible, &noRevert
+			info.Touches = []string{}
+			if d, ok := m.(migrate.Describer); ok && d.Describe().Areas != nil {
+				info.Touches = d.Describe().Areas
+			}
+		}
+
+		if fetcher != nil {
+			info.Source = sourceFetched
+			ver, err := migrations.LatestDistVersion(ctx, fetcher, info.Dist, false)
+			switch {
+			case err == nil:
+				info.DistVersion = ver
+			case !builtin:
+				// Past the last migration on the distribution site.
+				return list
+			default:
+				// Mirrors may hold only some of the migrations.
+				fmt.Fprintf(os.Stderr, "could not look up %s: %s\n", info.Dist, err)
+			}
+		} else if !builtin {
+			return list
+		}
+		list = append(list, info)
+	}
+}
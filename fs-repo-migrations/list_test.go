@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// builtinList is what list shows for each built-in migration.
+var builtinList = []struct {
+	migration  string
+	reversible bool
+	noRevert   bool
+	touches    []string
+}{
+	{"0-to-1", true, false, []string{}},
+	{"1-to-2", true, false, []string{"config", "blocks"}},
+	{"2-to-3", true, false, []string{"pins"}},
+	{"3-to-4", true, false, []string{"blocks", "ipns"}},
+	{"4-to-5", true, true, []string{"blocks"}},
+	{"5-to-6", true, true, []string{"config", "datastore_spec"}},
+	{"6-to-7", true, false, []string{"ipns"}},
+	{"7-to-8", true, false, []string{"config"}},
+	{"8-to-9", true, false, []string{"keystore"}},
+	{"9-to-10", true, false, []string{"config"}},
+	{"10-to-11", true, false, []string{"pins"}},
+	{"11-to-12", true, false, []string{"blocks", "pins"}},
+	{"12-to-13", true, true, []string{"config"}},
+	{"13-to-14", true, true, []string{"config"}},
+	{"14-to-15", true, true, []string{"config"}},
+	{"15-to-16", true, true, []string{"config"}},
+}
+
+func TestListMigrations(t *testing.T) {
+	list := listMigrations(nil)
+	if len(list) != len(builtinList) {
+		t.Fatalf("%d migrations listed, want %d", len(list), len(builtinList))
+	}
+	for i, want := range builtinList {
+		m := list[i]
+		if m.Migration != want.migration || m.Dist != "fs-repo-"+want.migration || !m.Builtin || m.Source != sourceBuiltin || m.DistVersion != "" {
+			t.Errorf("%s: listed as %+v", want.migration, m)
+			continue
+		}
+		if m.Reversible == nil || *m.Reversible != want.reversible {
+			t.Errorf("%s: reversible %v, want %v", want.migration, m.Reversible, want.reversible)
+		}
+		if m.NoRevert == nil || *m.NoRevert != want.noRevert {
+			t.Errorf("%s: no-revert %v, want %v", want.migration, m.NoRevert, want.noRevert)
+		}
+		if !reflect.DeepEqual(m.Touches, want.touches) {
+			t.Errorf("%s: touches %q, want %q", want.migration, m.Touches, want.touches)
+		}
+	}
+}
+
+// versionsFetcher serves the versions files of the dists in versions.
+type versionsFetcher map[string]string
+
+func (f versionsFetcher) Fetch(ctx context.Context, filePath string) (io.ReadCloser, error) {
+	dist := strings.TrimSuffix(filePath, "/versions")
+	vs, ok := f[dist]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(strings.NewReader(vs)), nil
+}
+
+func (f versionsFetcher) Close() error { return nil }
+
+func TestListMigrationsFetched(t *testing.T) {
+	fetcher := versionsFetcher{
+		"fs-repo-0-to-1":   "v1.0.0\n",
+		"fs-repo-11-to-12": "v1.0.1\nv1.0.2\nv1.0.3-dev\n",
+		"fs-repo-15-to-16": "v1.0.0\n",
+		"fs-repo-16-to-17": "v1.0.0\nv1.0.1\n",
+	}
+	stderr := os.Stderr
+	defer func() { os.Stderr = stderr }()
+	os.Stderr, _ = os.Open(os.DevNull)
+
+	list := listMigrations(fetcher)
+	if len(list) != len(builtinList)+1 {
+		t.Fatalf("%d migrations listed, want %d", len(list), len(builtinList)+1)
+	}
+	want := map[string]string{"0-to-1": "v1.0.0", "11-to-12": "v1.0.2", "15-to-16": "v1.0.0", "16-to-17": "v1.0.1"}
+	for _, m := range list {
+		if m.Source != sourceFetched || m.DistVersion != want[m.Migration] {
+			t.Errorf("%s: fetched as %+v, want version %q", m.Migration, m, want[m.Migration])
+		}
+	}
+	last := list[len(list)-1]
+	if last.Migration != "16-to-17" || last.Builtin || last.Reversible != nil || last.NoRevert != nil || last.Touches != nil {
+		t.Errorf("migration only on the distribution site listed as %+v", last)
+	}
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/ipfs/fs-repo-migrations/tools/backup"
+	"github.com/ipfs/fs-repo-migrations/tools/distmirror"
+	"github.com/ipfs/fs-repo-migrations/tools/distverify"
+	"github.com/ipfs/fs-repo-migrations/tools/stump"
 	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
 )
 
@@ -26,33 +33,164 @@ func yesNoPrompt(prompt string) bool {
 	}
 }
 
-func createFetcher(distPath string) migrations.Fetcher {
+// createFetcher returns the fetcher of the migrations in distPath. Fetching
+// over IPFS goes through the daemon of the repo at repoPath.
+func createFetcher(distPath, repoPath string, key ed25519.PublicKey, allowUnverified bool) (migrations.Fetcher, error) {
 	const userAgent = "fs-repo-migrations"
 
 	if distPath == "" {
 		distPath = migrations.GetDistPathEnv(migrations.LatestIpfsDist)
 	}
 
-	return migrations.NewMultiFetcher(
-		newIpfsFetcher(distPath, 0),
-		migrations.NewHttpFetcher(distPath, "", userAgent, 0))
+	var fetcher migrations.Fetcher
+	// A local mirror is used on its own, so that nothing goes to the
+	// network.
+	if distmirror.IsLocal(distPath) {
+		local, err := distmirror.Open(distPath)
+		if err != nil {
+			return nil, err
+		}
+		fetcher = local
+	} else {
+		fetcher = migrations.NewMultiFetcher(
+			newIpfsFetcher(distPath, repoPath, 0),
+			migrations.NewHttpFetcher(distPath, "", userAgent, 0))
+	}
+
+	// Archives are checked against the manifest of their dist before
+	// FetchBinary unpacks them.
+	return distverify.NewVerifier(fetcher, key, allowUnverified), nil
 }
 
 func main() {
-	distPath := flag.String("distpath", "", "specify the distributions build to use")
+	distPath := flag.String("distpath", "", "specify the distributions build to use, or a local mirror as file://DIR or car://FILE?root=CID (requires -fetch)")
+	fetch := flag.Bool("fetch", false, "download and run migration binaries instead of using the built-in migrations")
 	revertOk := flag.Bool("revert-ok", false, "allow running migrations backward")
-	targetStr := flag.String("to", "latest", "repo version to upgrade to, or \"latest\" for latest repo version")
+	targetStr := flag.String("to", "latest", "repo version to upgrade to, \"latest\" for latest repo version, or the one used by a release such as \"kubo@0.23.0\"")
 	version := flag.Bool("v", false, "print latest migration available and exit")
 	yes := flag.Bool("y", false, "answer yes to all prompts")
+	parallel := flag.Int("parallel", 1, "number of repos to migrate at once in batch mode")
+	var repoPatterns repoList
+	flag.Var(&repoPatterns, "repo", "path of the repo to migrate, or a glob pattern; give more than once to migrate several repos (default $IPFS_PATH)")
+	backupMode := flag.String("backup", "", "back up the repo before migrating, as a \"tar\" archive or a \"hardlink\" tree")
+	backupDir := flag.String("backup-dir", "", "directory to write the backup to (default: the directory containing the repo)")
+	backupSkipOutside := flag.Bool("backup-skip-outside", false, "back up the repo even if some of its datastores are outside it, leaving them out of the backup")
+	logFormat := flag.String("log-format", stump.FormatText, "output format, \"text\" or \"json\"")
+	trustedKeyFlag := flag.String("trusted-key", "", "Ed25519 public key, in base64 or hex or in a file, that must sign the "+distverify.Manifest+" of fetched migrations (default: the pinned key)")
+	pinKey := flag.Bool("pin-key", false, "pin -trusted-key, so that later runs require it without being given it")
+	skipSpaceCheck := flag.Bool("skip-space-check", false, "do not check that there is enough free disk space before each migration")
+	dryRun := flag.Bool("dry-run", false, "report what the next migration would change, without modifying the repo")
+	allowUnverified := flag.Bool("allow-unverified", false, "run fetched migrations whose dist has no "+distverify.Manifest+" and whose archive has no "+distverify.DigestSuffix+" digest, when there is no trusted key")
+	registerMigrationFlags()
 	flag.Parse()
 
 	if flag.NArg() != 0 {
+		switch flag.Arg(0) {
+		case "restore":
+			os.Exit(restoreMain(flag.Args()[1:]))
+		case "check":
+			os.Exit(checkMain(flag.Args()[1:]))
+		case "cleanup":
+			os.Exit(cleanupMain(flag.Args()[1:]))
+		case "list":
+			os.Exit(listMain(flag.Args()[1:]))
+		case "info":
+			os.Exit(infoMain(flag.Args()[1:]))
+		}
+		if status, ok := commandMain(flag.Args()); ok {
+			os.Exit(status)
+		}
 		fmt.Fprintln(os.Stderr, "unrecognized arguments")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	fetcher := createFetcher(*distPath)
+	switch *backupMode {
+	case "", backup.ModeTar, backup.ModeHardlink:
+	default:
+		fmt.Fprintf(os.Stderr, "unknown backup mode %q, must be %q or %q\n", *backupMode, backup.ModeTar, backup.ModeHardlink)
+		os.Exit(1)
+	}
+
+	if *dryRun && (*fetch || *backupMode != "") {
+		fmt.Fprintln(os.Stderr, "-dry-run cannot be used with -fetch or -backup")
+		os.Exit(1)
+	}
+
+	switch *logFormat {
+	case stump.FormatText:
+	case stump.FormatJSON:
+		// The output of fetched binaries is passed through as is, and a
+		// prompt would break the event stream.
+		if *fetch {
+			fmt.Fprintln(os.Stderr, "-log-format=json cannot be used with -fetch")
+			os.Exit(1)
+		}
+		if !*yes && !*version && !*dryRun {
+			fmt.Fprintln(os.Stderr, "-log-format=json requires -y or -dry-run")
+			os.Exit(1)
+		}
+		stump.Format = stump.FormatJSON
+	default:
+		fmt.Fprintf(os.Stderr, "unknown log format %q, must be %q or %q\n", *logFormat, stump.FormatText, stump.FormatJSON)
+		os.Exit(1)
+	}
+
+	// The empty repo path tells the migrations package to use IPFS_PATH.
+	var (
+		repos   []string
+		repoDir string
+	)
+	if len(repoPatterns) != 0 {
+		var err error
+		if repos, err = expandRepos(repoPatterns); err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+			os.Exit(1)
+		}
+		if len(repos) == 1 {
+			repoDir = repos[0]
+			stump.Repo = repoDir
+		}
+	}
+
+	// Migrations are compiled into this binary. Only go to the network when
+	// explicitly asked to.
+	var (
+		fetcher   migrations.Fetcher
+		fetchArgs []string
+	)
+	if *fetch {
+		key, err := trustedKey(*trustedKeyFlag, *pinKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+			os.Exit(1)
+		}
+		// In batch mode, each repo is migrated by a run of its own that
+		// fetches through the daemon of that repo.
+		if fetcher, err = createFetcher(*distPath, repoDir, key, *allowUnverified); err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+			os.Exit(1)
+		}
+		fetchArgs = []string{"-fetch"}
+		if *distPath != "" {
+			fetchArgs = append(fetchArgs, "-distpath", *distPath)
+		}
+		if key != nil {
+			fetchArgs = append(fetchArgs, "-trusted-key", base64.StdEncoding.EncodeToString(key))
+		}
+		if *allowUnverified {
+			fetchArgs = append(fetchArgs, "-allow-unverified")
+		}
+		if set := migrationFlagArgs(); len(set) != 0 {
+			// Kubo's migrations package runs fetched binaries with
+			// fixed arguments.
+			fmt.Fprintln(os.Stderr, "the options of the built-in migrations cannot be passed to fetched ones:", strings.Join(set, " "))
+			os.Exit(1)
+		}
+	} else if *distPath != "" || *trustedKeyFlag != "" || *pinKey || *allowUnverified {
+		fmt.Fprintln(os.Stderr, "-distpath, -trusted-key, -pin-key and -allow-unverified are only used together with -fetch")
+		os.Exit(1)
+	}
 
 	var (
 		err    error
@@ -60,7 +198,7 @@ func main() {
 	)
 
 	if *version {
-		target, err = latestRepoMigration(fetcher)
+		target, err = latestMigration(fetcher)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
@@ -74,20 +212,30 @@ func main() {
 		fmt.Fprintln(os.Stderr, "Please specify a target version to migrate the repo to")
 		os.Exit(1)
 	case "latest":
-		target, err = latestRepoMigration(fetcher)
+		target, err = latestMigration(fetcher)
 		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 	default:
-		target, err = strconv.Atoi(*targetStr)
-		if err != nil || target < 1 {
-			fmt.Fprintln(os.Stderr, "invalid target version, \"to\" must be a positive integer")
-			os.Exit(1)
+		var isRelease bool
+		target, isRelease, err = parseKuboTarget(*targetStr)
+		if isRelease {
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "invalid target version:", err)
+				os.Exit(1)
+			}
+			stump.Log("%s uses repo version %d", *targetStr, target)
+		} else {
+			target, err = strconv.Atoi(*targetStr)
+			if err != nil || target < 1 {
+				fmt.Fprintln(os.Stderr, "invalid target version, \"to\" must be a positive integer, \"latest\" or a release such as \"kubo@0.23.0\"")
+				os.Exit(1)
+			}
 		}
 		// Check that target is not greater than latest version.  Ignore error
 		// here since may be offline and all migrations already in PATH.
-		latest, _ := latestRepoMigration(fetcher)
+		latest, _ := latestMigration(fetcher)
 		if latest != 0 && target > latest {
 			fmt.Fprintln(os.Stderr, "migration version", target, "does not exist, latest is", latest)
 			os.Exit(1)
@@ -95,13 +243,21 @@ func main() {
 
 	}
 
-	vnum, err := migrations.RepoVersion("")
+	warnInstalledKubo(target)
+
+	if len(repos) > 1 {
+		os.Exit(batchMain(repos, target, *parallel, *yes, *revertOk, *skipSpaceCheck, *dryRun, fetchArgs, *backupMode, *backupDir, *backupSkipOutside))
+	}
+
+	vnum, err := migrations.RepoVersion(repoDir)
 	if err != nil {
+		stump.Emit(stump.Event{Type: stump.EventError, Error: err.Error()})
 		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
 		os.Exit(1)
 	}
 
 	if vnum >= 17 {
+		stump.Emit(stump.Event{Type: stump.EventError, Error: fmt.Sprintf("repo version %d is not supported by this tool, use the built-in migration in Kubo", vnum)})
 		fmt.Fprintln(os.Stderr, "ipfs migration: repo version", vnum, "is not supported by this tool")
 		fmt.Fprintln(os.Stderr, "For repo version 17 or later, use the built-in migration in Kubo:")
 		fmt.Fprintln(os.Stderr, "  ipfs daemon --migrate")
@@ -111,29 +267,104 @@ func main() {
 	}
 
 	if vnum > target && !*revertOk {
+		stump.Emit(stump.Event{Type: stump.EventError, Error: fmt.Sprintf("attempt to run backward migration from version %d to %d without -revert-ok", vnum, target)})
 		fmt.Fprintln(os.Stderr, "ipfs migration: attempt to run backward migration\nTo allow, run this command again with --revert-ok")
 		os.Exit(1)
 	}
 
 	if vnum == target {
+		stump.Emit(stump.Event{Type: stump.EventSkipped, Message: fmt.Sprintf("already at version %d", target)})
 		fmt.Fprintln(os.Stderr, "ipfs migration: already at version", target)
 		return
 	}
 
-	ipfsDir, _ := migrations.IpfsDir("")
-	fmt.Printf("Found fs-repo version %d at %s\n", vnum, ipfsDir)
+	ipfsDir, _ := migrations.IpfsDir(repoDir)
+	stump.Log("Found fs-repo version %d at %s", vnum, ipfsDir)
 	prompt := fmt.Sprintf("Do you want to upgrade this to version %d? [y/n]", target)
-	if !(*yes || yesNoPrompt(prompt)) {
+	if !(*yes || *dryRun || yesNoPrompt(prompt)) {
 		os.Exit(1)
 	}
 
-	err = migrations.RunMigration(context.Background(), fetcher, target, "", *revertOk)
+	if *backupMode != "" {
+		backupPath, err := backup.Create(ipfsDir, *backupDir, *backupMode, *backupSkipOutside)
+		if err != nil {
+			stump.Emit(stump.Event{Type: stump.EventError, Error: err.Error()})
+			fmt.Fprintln(os.Stderr, "ipfs migration: backup failed: ", err)
+			os.Exit(1)
+		}
+		stump.Log("Backed up repo to %s", backupPath)
+		stump.Log("To undo the migration, run: fs-repo-migrations restore -repo %s %s", ipfsDir, backupPath)
+	}
+
+	if fetcher != nil {
+		err = migrations.RunMigration(context.Background(), fetcher, target, repoDir, *revertOk)
+	} else {
+		err = runBuiltinMigrations(repoDir, target, *revertOk, *skipSpaceCheck, *dryRun)
+	}
 	if err != nil {
+		stump.Emit(stump.Event{Type: stump.EventError, Error: err.Error()})
 		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
 		os.Exit(1)
 	}
 }
 
+// batchMain migrates each of repos to target and prints a summary. It returns
+// the exit status of the program.
+func batchMain(repos []string, target, parallel int, yes, revertOk, skipSpaceCheck, dryRun bool, fetchArgs []string, backupMode, backupDir string, backupSkipOutside bool) int {
+	if !yes && !dryRun {
+		fmt.Printf("Found %d repos:\n", len(repos))
+		for _, repo := range repos {
+			fmt.Println(" ", repo)
+		}
+		prompt := fmt.Sprintf("Do you want to migrate all of them to version %d? [y/n]", target)
+		if !yesNoPrompt(prompt) {
+			return 1
+		}
+	}
+
+	// Pass the options that apply to each repo on to the per-repo runs.
+	args := []string{"-log-format", stump.Format}
+	if revertOk {
+		args = append(args, "-revert-ok")
+	}
+	if skipSpaceCheck {
+		args = append(args, "-skip-space-check")
+	}
+	if dryRun {
+		args = append(args, "-dry-run")
+	}
+	args = append(args, fetchArgs...)
+	args = append(args, migrationFlagArgs()...)
+
+	if backupMode != "" {
+		args = append(args, "-backup", backupMode)
+		if backupDir != "" {
+			args = append(args, "-backup-dir", backupDir)
+		}
+		if backupSkipOutside {
+			args = append(args, "-backup-skip-outside")
+		}
+	}
+
+	results := runBatch(repos, target, parallel, dryRun, args)
+	printSummary(os.Stdout, results)
+	if err := batchErr(results); err != nil {
+		stump.Emit(stump.Event{Type: stump.EventError, Error: err.Error()})
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	}
+	return 0
+}
+
+// latestMigration returns the latest repo version available, either from the
+// built-in migrations or, when fetcher is not nil, from the distribution site.
+func latestMigration(fetcher migrations.Fetcher) (int, error) {
+	if fetcher == nil {
+		return latestBuiltinMigration(), nil
+	}
+	return latestRepoMigration(fetcher)
+}
+
 func latestRepoMigration(fetcher migrations.Fetcher) (int, error) {
 	// TODO: Remove currentVersion and get list of all fs-repo-*-to-* in one
 	// request and calculate latest
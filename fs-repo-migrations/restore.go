@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ipfs/fs-repo-migrations/tools/backup"
+	"github.com/ipfs/go-ipfs/repo/fsrepo/migrations"
+)
+
+// restoreMain implements the "restore" subcommand, which rolls a repo back to
+// a backup made with -backup. It returns the exit status of the program.
+func restoreMain(args []string) int {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s restore [options] <backup>\n\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Restore a repo from a backup made with -backup. The backup is checked")
+		fmt.Fprintln(fs.Output(), "against its manifest before anything in the repo is changed. Datastores")
+		fmt.Fprintln(fs.Output(), "and migration files added to the repo after the backup are removed.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+	repo := fs.String("repo", "", "path of the repo to restore (default $IPFS_PATH)")
+	verifyOnly := fs.Bool("verify", false, "only check the backup against its manifest")
+	yes := fs.Bool("y", false, "answer yes to all prompts")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+	backupPath := fs.Arg(0)
+
+	if *verifyOnly {
+		info, err := backup.Verify(backupPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+			return 1
+		}
+		fmt.Printf("Backup of %s at version %s, taken %s, is intact\n", info.Repo, info.Version, info.Created.Format("2006-01-02 15:04:05 MST"))
+		return 0
+	}
+
+	// Restore checks the backup as it unpacks it, so it is only read once.
+	info, err := backup.ReadInfo(backupPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	}
+	fmt.Printf("Backup of %s at version %s, taken %s\n", info.Repo, info.Version, info.Created.Format("2006-01-02 15:04:05 MST"))
+
+	ipfsDir, err := migrations.IpfsDir(*repo)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: ", err)
+		return 1
+	}
+	if ipfsDir != info.Repo {
+		fmt.Printf("Note: the backup was taken from %s\n", info.Repo)
+	}
+	if added, err := backup.AddedSince(ipfsDir, info); err == nil && len(added) != 0 {
+		fmt.Println("These were added to the repo after the backup, and will be removed:")
+		for _, p := range added {
+			fmt.Println(" ", p)
+		}
+	}
+	prompt := fmt.Sprintf("Do you want to restore the repo at %s to this backup? [y/n]", ipfsDir)
+	if !(*yes || yesNoPrompt(prompt)) {
+		return 1
+	}
+
+	if _, err = backup.Restore(backupPath, ipfsDir); err != nil {
+		fmt.Fprintln(os.Stderr, "ipfs migration: restore failed: ", err)
+		return 1
+	}
+	fmt.Printf("Success: repo at %s restored to version %s.\n", ipfsDir, info.Version)
+	return 0
+}
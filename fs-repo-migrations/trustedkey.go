@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/ipfs/fs-repo-migrations/tools/distverify"
+)
+
+// pinnedKeyFile is where -pin-key stores the trusted key, below the user's
+// config directory.
+const pinnedKeyFile = "fs-repo-migrations/trusted-key"
+
+// trustedKey returns the key that the manifests of fetched migrations must be
+// signed with, or nil if there is none. flagVal is the -trusted-key flag: a
+// key in base64 or hex, or the path of a file holding one. Without it, the
+// pinned key is used, if any. If pin is set, flagVal replaces the pinned key.
+func trustedKey(flagVal string, pin bool) (ed25519.PublicKey, error) {
+	pinPath, err := pinnedKeyPath()
+	if err != nil {
+		if pin {
+			return nil, err
+		}
+		pinPath = ""
+	}
+
+	var pinned ed25519.PublicKey
+	if pinPath != "" {
+		data, err := ioutil.ReadFile(pinPath)
+		if err == nil {
+			if pinned, err = distverify.ParseKey(string(data)); err != nil {
+				return nil, fmt.Errorf("%s: %s", pinPath, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if flagVal == "" {
+		if pin {
+			return nil, fmt.Errorf("-pin-key requires -trusted-key")
+		}
+		return pinned, nil
+	}
+
+	if data, err := ioutil.ReadFile(flagVal); err == nil {
+		flagVal = string(data)
+	}
+	key, err := distverify.ParseKey(flagVal)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case pin:
+		if err = os.MkdirAll(filepath.Dir(pinPath), 0700); err != nil {
+			return nil, err
+		}
+		enc := base64.StdEncoding.EncodeToString(key) + "\n"
+		if err = ioutil.WriteFile(pinPath, []byte(enc), 0600); err != nil {
+			return nil, err
+		}
+		fmt.Printf("Pinned trusted key %s in %s\n", enc[:len(enc)-1], pinPath)
+	case pinned != nil && !bytes.Equal(key, pinned):
+		return nil, fmt.Errorf("-trusted-key is not the key pinned in %s; use -pin-key to replace it", pinPath)
+	}
+	return key, nil
+}
+
+func pinnedKeyPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.FromSlash(pinnedKeyFile)), nil
+}